@@ -4,8 +4,6 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
-	"strconv"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -13,22 +11,35 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
 )
 
-func DbInstance() *sql.DB {
-	return newDbInstance(os.Getenv("database_host"))
+// DatabaseConfig holds the MySQL connection and pool settings
+type DatabaseConfig struct {
+	Host               string `mapstructure:"database_host"`
+	ReadHost           string `mapstructure:"database_host_read"` // replica for reads, the primary when empty
+	Port               int    `mapstructure:"database_port"`
+	Username           string `mapstructure:"database_username"`
+	Password           string `mapstructure:"database_password"`
+	Name               string `mapstructure:"database_name"`
+	IdleConnections    int    `mapstructure:"database_idle_connection"`
+	MaxConnections     int    `mapstructure:"database_max_connection"`
+	ConnectionLifetime int    `mapstructure:"database_connection_lifetime"` // seconds
 }
 
-func DbInstanceSlave() *sql.DB {
-	return newDbInstance(os.Getenv("database_host_read"))
+func DbInstance(config DatabaseConfig) *sql.DB {
+	return newDbInstance(config, config.Host)
 }
 
-func newDbInstance(host string) *sql.DB {
-	username := os.Getenv("database_username")
-	password := os.Getenv("database_password")
-	dbname := os.Getenv("database_name")
-	port := os.Getenv("database_port")
+func DbInstanceSlave(config DatabaseConfig) *sql.DB {
+	if config.ReadHost == "" {
+		return newDbInstance(config, config.Host)
+	}
+	return newDbInstance(config, config.ReadHost)
+}
 
-	dbURI := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&multiStatements=true",
-		username, password, host, port, dbname)
+func newDbInstance(config DatabaseConfig, host string) *sql.DB {
+	dbname := config.Name
+
+	dbURI := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&multiStatements=true",
+		config.Username, config.Password, host, config.Port, dbname)
 
 	db, err := otelsql.Open("mysql", dbURI,
 		otelsql.WithAttributes(semconv.DBSystemMySQL),
@@ -37,14 +48,10 @@ func newDbInstance(host string) *sql.DB {
 	checkErrFatal(err)
 
 	// Connection pool settings
-	idle := fallbackEnvInt("database_idle_connection", 5)
-	maxConn := fallbackEnvInt("database_max_connection", 10)
-	lifetime := fallbackEnvInt("database_connection_lifetime", 60)
-
-	db.SetMaxIdleConns(idle)
-	db.SetMaxOpenConns(maxConn)
-	db.SetConnMaxLifetime(time.Second * time.Duration(lifetime))
-	db.SetConnMaxIdleTime(time.Second * time.Duration(lifetime))
+	db.SetMaxIdleConns(config.IdleConnections)
+	db.SetMaxOpenConns(config.MaxConnections)
+	db.SetConnMaxLifetime(time.Second * time.Duration(config.ConnectionLifetime))
+	db.SetConnMaxIdleTime(time.Second * time.Duration(config.ConnectionLifetime))
 
 	otelsql.ReportDBStatsMetrics(db)
 
@@ -54,14 +61,6 @@ func newDbInstance(host string) *sql.DB {
 	return db
 }
 
-func fallbackEnvInt(envKey string, fallback int) int {
-	val, err := strconv.Atoi(os.Getenv(envKey))
-	if err != nil {
-		return fallback
-	}
-	return val
-}
-
 func checkErrFatal(err error) {
 	if err != nil {
 		log.Fatalf("DB ERROR: %s", err.Error())
@@ -0,0 +1,317 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers carried by retried and dead-lettered messages
+const (
+	HeaderAttempts      = "x-attempts"       // failed deliveries so far
+	HeaderError         = "x-last-error"     // error of the last failed delivery
+	HeaderOriginalQueue = "x-original-queue" // queue the message was consumed from
+	HeaderFailedAt      = "x-failed-at"      // time of the last failed delivery
+)
+
+// ErrDeferred is matched by the errors of Deferred
+var ErrDeferred = errors.New("message deferred")
+
+// deferral postpones a message that cannot be handled yet
+type deferral struct {
+	after  time.Duration
+	reason string
+}
+
+func (d *deferral) Error() string {
+	return fmt.Sprintf("%s, deferred for %s", d.reason, d.after)
+}
+
+func (d *deferral) Unwrap() error {
+	return ErrDeferred
+}
+
+// Deferred returns the error a handler gives for a message it cannot handle for
+// another after, such as an SMS over its recipient's throttle. The message waits in
+// a retry queue instead of blocking a worker, and the wait is not a failed attempt.
+func Deferred(after time.Duration, reason string) error {
+	return &deferral{after: after, reason: reason}
+}
+
+// RetryPolicy bounds how often a failed message is redelivered before it is dead-lettered
+type RetryPolicy struct {
+	MaxAttempts   int           // deliveries before the message moves to the DLQ
+	RetryDelay    time.Duration // delay before the first retry, doubled on every further retry
+	MaxRetryDelay time.Duration
+}
+
+// RetryPolicy returns the queue_max_attempts, queue_retry_delay and queue_retry_max_delay
+// policy; the topology file overrides it per queue
+func (c Config) RetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:   c.MaxAttempts,
+		RetryDelay:    time.Duration(c.RetryDelay) * time.Second,
+		MaxRetryDelay: time.Duration(c.RetryMaxDelay) * time.Second,
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return policy
+}
+
+// Delay returns the backoff before redelivering a message that has failed attempts times
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.RetryDelay << uint(attempts-1)
+	if delay > p.MaxRetryDelay || delay <= 0 {
+		delay = p.MaxRetryDelay
+	}
+	return delay
+}
+
+// DeadLetterQueue returns the name of the DLQ of a queue
+func DeadLetterQueue(queueName string) string {
+	return queueName + ".dlq"
+}
+
+// RetryQueue returns the delay queue holding messages of queueName for delay
+func RetryQueue(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queueName, delay)
+}
+
+// UseTopology switches the client to a validated topology and declares all of it:
+// every exchange, then every queue with its bindings, DLQ and retry queues
+func (c *RabbitMQClient) UseTopology(t *Topology) error {
+	c.mu.Lock()
+	c.topology = t
+	c.mu.Unlock()
+	c.resetTopology()
+
+	ch, err := c.openChannel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, e := range t.Exchanges {
+		if err := ch.ExchangeDeclare(e.Name, e.Type, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %w", e.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if _, err := c.declareTopology(ch, q.Name); err != nil {
+			return err
+		}
+		c.pool.declared.Store(q.Name, struct{}{})
+		c.known.Store(q.Name, struct{}{})
+	}
+	return nil
+}
+
+// Topology returns the topology in use, nil when queues use the legacy layout
+func (c *RabbitMQClient) Topology() *Topology {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topology
+}
+
+// layout returns the topology a queue is declared from
+func (c *RabbitMQClient) layout(queueName string) *Topology {
+	if t := c.Topology(); t != nil {
+		return t
+	}
+	return legacyTopology(queueName)
+}
+
+// policyFor returns the retry policy of a queue with the topology's settings applied
+func (c *RabbitMQClient) policyFor(queueName string) RetryPolicy {
+	policy := c.config.RetryPolicy()
+
+	spec, ok := c.layout(queueName).Queue(queueName)
+	if !ok {
+		return policy
+	}
+	if spec.MaxAttempts > 0 {
+		policy.MaxAttempts = spec.MaxAttempts
+	}
+	if spec.RetryDelay > 0 {
+		policy.RetryDelay = time.Duration(spec.RetryDelay) * time.Second
+	}
+	if spec.RetryMaxDelay > 0 {
+		policy.MaxRetryDelay = time.Duration(spec.RetryMaxDelay) * time.Second
+	}
+	return policy
+}
+
+// declareTopology declares queueName as configured, with the exchanges and bindings
+// routing to it. Consumed queues also get their DLQ and one delay queue per backoff
+// step. Delay queues have no consumers: messages expire after the queue's TTL and are
+// dead-lettered back through the default exchange, which reaches the source queue
+// alone rather than every queue bound to its exchange.
+func (c *RabbitMQClient) declareTopology(ch *amqp.Channel, queueName string) (amqp.Queue, error) {
+	t := c.layout(queueName)
+	spec, ok := t.Queue(queueName)
+	if !ok {
+		return amqp.Queue{}, fmt.Errorf("%w: %s", ErrUnknownQueue, queueName)
+	}
+
+	q, err := ch.QueueDeclare(
+		queueName,        // name
+		true,             // durable
+		false,            // delete when unused
+		false,            // exclusive
+		false,            // no-wait
+		spec.arguments(), // arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	}
+
+	for _, b := range t.Bindings {
+		if b.Queue != queueName {
+			continue
+		}
+		exchange := t.exchange(b.Exchange)
+		if err := ch.ExchangeDeclare(exchange.Name, exchange.Type, true, false, false, false, nil); err != nil {
+			return amqp.Queue{}, fmt.Errorf("failed to declare exchange %s: %w", exchange.Name, err)
+		}
+		if err := ch.QueueBind(q.Name, b.RoutingKey, b.Exchange, false, nil); err != nil {
+			return amqp.Queue{}, fmt.Errorf("failed to bind queue %s to exchange %s: %w", queueName, b.Exchange, err)
+		}
+	}
+
+	if spec.Handler == "" {
+		return q, nil
+	}
+
+	if _, err := ch.QueueDeclare(DeadLetterQueue(queueName), true, false, false, false, nil); err != nil {
+		return amqp.Queue{}, fmt.Errorf("failed to declare dead letter queue of %s: %w", queueName, err)
+	}
+
+	policy := c.policyFor(queueName)
+	declared := make(map[time.Duration]bool)
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		delay := policy.Delay(attempt)
+		if declared[delay] {
+			continue
+		}
+		declared[delay] = true
+
+		_, err := ch.QueueDeclare(RetryQueue(queueName, delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		})
+		if err != nil {
+			return amqp.Queue{}, fmt.Errorf("failed to declare retry queue of %s: %w", queueName, err)
+		}
+	}
+
+	return q, nil
+}
+
+// Retry schedules a failed delivery of queueName for redelivery after the backoff
+// of its policy, or moves it to the DLQ once MaxAttempts deliveries have failed.
+// The caller acks the original delivery when Retry succeeds.
+func (c *RabbitMQClient) Retry(ctx context.Context, queueName string, delivery amqp.Delivery, cause error) (deadLettered bool, err error) {
+	policy := c.policyFor(queueName)
+	attempts := HeaderInt(delivery.Headers, HeaderAttempts) + 1
+
+	if attempts >= policy.MaxAttempts {
+		return true, c.moveMessage(ctx, DeadLetterQueue(queueName), queueName, delivery, attempts, cause)
+	}
+	return false, c.moveMessage(ctx, RetryQueue(queueName, policy.Delay(attempts)), queueName, delivery, attempts, cause)
+}
+
+// Defer moves a delivery its handler deferred to the retry queue with the longest
+// delay not beyond the deferral, keeping its attempt count. Queues without retry
+// queues cannot defer. The caller acks the original delivery when Defer succeeds.
+func (c *RabbitMQClient) Defer(ctx context.Context, queueName string, delivery amqp.Delivery, cause error) error {
+	policy := c.policyFor(queueName)
+	if policy.MaxAttempts < 2 {
+		return fmt.Errorf("queue %s has no retry queue to defer to", queueName)
+	}
+
+	var after time.Duration
+	var d *deferral
+	if errors.As(cause, &d) {
+		after = d.after
+	}
+
+	delay := policy.Delay(1)
+	for attempt := 2; attempt < policy.MaxAttempts; attempt++ {
+		if next := policy.Delay(attempt); next <= after && next > delay {
+			delay = next
+		}
+	}
+
+	attempts := HeaderInt(delivery.Headers, HeaderAttempts)
+	return c.moveMessage(ctx, RetryQueue(queueName, delay), queueName, delivery, attempts, cause)
+}
+
+// DeadLetter moves a delivery that can never succeed straight to the DLQ of queueName.
+// The caller acks the original delivery when DeadLetter succeeds.
+func (c *RabbitMQClient) DeadLetter(ctx context.Context, queueName string, delivery amqp.Delivery, cause error) error {
+	attempts := HeaderInt(delivery.Headers, HeaderAttempts) + 1
+	return c.moveMessage(ctx, DeadLetterQueue(queueName), queueName, delivery, attempts, cause)
+}
+
+// moveMessage republishes a delivery to target with its failure recorded in the headers
+func (c *RabbitMQClient) moveMessage(ctx context.Context, target, queueName string, delivery amqp.Delivery, attempts int, cause error) error {
+	headers := amqp.Table{}
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	headers[HeaderAttempts] = int32(attempts)
+	headers[HeaderError] = cause.Error()
+	headers[HeaderOriginalQueue] = queueName
+	headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	// Dead-lettered messages are picked out by id for replay and purge
+	messageID := delivery.MessageId
+	if messageID == "" {
+		messageID = uuid.NewString()
+	}
+
+	err := c.publishConfirmed(ctx, "", target, "", amqp.Publishing{
+		Headers:       headers,
+		ContentType:   delivery.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		Priority:      delivery.Priority,
+		CorrelationId: delivery.CorrelationId,
+		MessageId:     messageID,
+		Timestamp:     delivery.Timestamp,
+		Type:          delivery.Type,
+		Body:          delivery.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move message to %s: %w", target, err)
+	}
+	return nil
+}
+
+// HeaderInt reads an integer header written by any AMQP client
+func HeaderInt(headers amqp.Table, key string) int {
+	switch v := headers[key].(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		return int(v)
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// TestRetryStaysOnItsQueue fails a message of one of two queues bound to the same fanout
+// exchange and checks its retry and replay reach that queue alone. It needs a broker
+// (rabbitmq_* environment) and is skipped when none can be reached.
+func TestRetryStaysOnItsQueue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("needs RabbitMQ")
+	}
+
+	client, err := NewRabbitMQClient(NewConfig())
+	if err != nil {
+		t.Skipf("RabbitMQ not reachable: %v", err)
+	}
+	defer client.Close()
+
+	exchange := fmt.Sprintf("retry_test.%d", time.Now().UnixNano())
+	first, second := exchange+".first", exchange+".second"
+	err = client.UseTopology(&Topology{
+		Exchanges: []ExchangeSpec{{Name: exchange, Type: amqp.ExchangeFanout}},
+		Queues: []QueueSpec{
+			{Name: first, Handler: "first", MaxAttempts: 2, RetryDelay: 1, RetryMaxDelay: 1},
+			{Name: second, Handler: "second", MaxAttempts: 2, RetryDelay: 1, RetryMaxDelay: 1},
+		},
+		Bindings: []BindingSpec{{Exchange: exchange, Queue: first}, {Exchange: exchange, Queue: second}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ch, err := client.openChannel()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+		for _, q := range []string{first, second} {
+			for _, name := range []string{q, DeadLetterQueue(q), RetryQueue(q, time.Second)} {
+				ch.QueueDelete(name, false, false, false)
+			}
+		}
+		ch.ExchangeDelete(exchange, false, false)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := client.Publish(ctx, first, NewEnvelope(ctx, "test"), map[string]string{"kind": "retry"}, PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	firstSub, err := client.Consume(ctx, first, "retry-test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer firstSub.Close()
+	secondSub, err := client.Consume(ctx, second, "retry-test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondSub.Close()
+
+	next := func() amqp.Delivery {
+		select {
+		case d := <-firstSub.Deliveries:
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message did not come back to %s", first)
+			return amqp.Delivery{}
+		}
+	}
+
+	// The first failure goes through the retry queue, the second to the DLQ
+	for _, wantDeadLettered := range []bool{false, true} {
+		d := next()
+		deadLettered, err := client.Retry(ctx, first, d, errors.New("handler failed"))
+		if err != nil || deadLettered != wantDeadLettered {
+			t.Fatalf("Retry = %v, %v; want dead lettered %v", deadLettered, err, wantDeadLettered)
+		}
+		d.Ack(false)
+	}
+	if _, err := client.ReplayDeadLetters(ctx, first, nil); err != nil {
+		t.Fatal(err)
+	}
+	next().Ack(false)
+
+	select {
+	case d := <-secondSub.Deliveries:
+		t.Errorf("%s received message %s of %s", second, d.MessageId, first)
+	default:
+	}
+}
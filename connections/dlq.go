@@ -0,0 +1,209 @@
+package connections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetterMessage is a message held in the DLQ of a queue
+type DeadLetterMessage struct {
+	MessageID     string          `json:"message_id"`
+	Type          string          `json:"type"`
+	CorrelationID string          `json:"correlation_id"`
+	OriginalQueue string          `json:"original_queue"`
+	Attempts      int             `json:"attempts"`
+	Error         string          `json:"error"`
+	FailedAt      string          `json:"failed_at"`
+	Priority      uint8           `json:"priority"`
+	Headers       amqp.Table      `json:"headers"`
+	Body          json.RawMessage `json:"body"`
+}
+
+// DeadLetterCount returns the number of messages in the DLQ of queueName
+func (c *RabbitMQClient) DeadLetterCount(queueName string) (int, error) {
+	ch, err := c.openChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclarePassive(DeadLetterQueue(queueName), true, false, false, false, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect dead letter queue of %s: %w", queueName, err)
+	}
+	return q.Messages, nil
+}
+
+// PeekDeadLetters returns up to limit messages from the DLQ of queueName without removing them
+func (c *RabbitMQClient) PeekDeadLetters(ctx context.Context, queueName string, limit int) ([]DeadLetterMessage, error) {
+	messages := []DeadLetterMessage{}
+	err := c.walkDeadLetters(ctx, queueName, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if len(messages) >= limit {
+			return false, errStopWalk
+		}
+		messages = append(messages, deadLetterMessage(d))
+		return false, nil
+	})
+	return messages, err
+}
+
+// ReplayDeadLetters moves messages from the DLQ of queueName back to the queue with a
+// fresh attempt count. All messages are replayed when ids is empty. It returns the ids replayed.
+func (c *RabbitMQClient) ReplayDeadLetters(ctx context.Context, queueName string, ids []string) ([]string, error) {
+	replayed := []string{}
+	err := c.walkDeadLetters(ctx, queueName, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if !selected(ids, d.MessageId) {
+			return false, nil
+		}
+
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		delete(headers, HeaderAttempts)
+
+		err := c.publishConfirmed(ctx, "", queueName, "", amqp.Publishing{
+			Headers:       headers,
+			ContentType:   d.ContentType,
+			DeliveryMode:  amqp.Persistent,
+			Priority:      d.Priority,
+			CorrelationId: d.CorrelationId,
+			MessageId:     d.MessageId,
+			Timestamp:     d.Timestamp,
+			Type:          d.Type,
+			Body:          d.Body,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to replay message %s: %w", d.MessageId, err)
+		}
+		replayed = append(replayed, d.MessageId)
+		return true, nil
+	})
+	return replayed, err
+}
+
+// PurgeDeadLetters deletes messages from the DLQ of queueName, all of them when ids is empty.
+// It returns the number of messages deleted.
+func (c *RabbitMQClient) PurgeDeadLetters(ctx context.Context, queueName string, ids []string) (int, error) {
+	if len(ids) == 0 {
+		ch, err := c.openChannel()
+		if err != nil {
+			return 0, err
+		}
+		defer ch.Close()
+
+		purged, err := ch.QueuePurge(DeadLetterQueue(queueName), false)
+		if err != nil {
+			return 0, fmt.Errorf("failed to purge dead letter queue of %s: %w", queueName, err)
+		}
+		return purged, nil
+	}
+
+	purged := 0
+	err := c.walkDeadLetters(ctx, queueName, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if !selected(ids, d.MessageId) {
+			return false, nil
+		}
+		purged++
+		return true, nil
+	})
+	return purged, err
+}
+
+var errStopWalk = errors.New("stop walking dead letter queue")
+
+// walkDeadLetters fetches every message currently in the DLQ of queueName once and
+// passes it to fn. Messages fn reports as handled are acked; the rest go back to
+// the DLQ in their original order when the channel closes.
+func (c *RabbitMQClient) walkDeadLetters(ctx context.Context, queueName string, fn func(ch *amqp.Channel, d amqp.Delivery) (bool, error)) error {
+	ch, err := c.openChannel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	dlq := DeadLetterQueue(queueName)
+	q, err := ch.QueueDeclarePassive(dlq, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to inspect dead letter queue of %s: %w", queueName, err)
+	}
+
+	// Unacked messages are not redelivered on this channel, so each is seen once
+	for i := 0; i < q.Messages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		d, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return fmt.Errorf("failed to read dead letter queue of %s: %w", queueName, err)
+		}
+		if !ok {
+			return nil
+		}
+
+		handled, err := fn(ch, d)
+		if err == errStopWalk {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if handled {
+			if err := d.Ack(false); err != nil {
+				return fmt.Errorf("failed to remove message %s from dead letter queue: %w", d.MessageId, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// openChannel opens a channel for a one off operation; the caller closes it
+func (c *RabbitMQClient) openChannel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	ch, err := c.conn.Channel()
+	c.mu.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("error opening RabbitMQ channel: %w", err)
+	}
+	return ch, nil
+}
+
+func deadLetterMessage(d amqp.Delivery) DeadLetterMessage {
+	body := json.RawMessage(d.Body)
+	if !json.Valid(d.Body) {
+		body, _ = json.Marshal(string(d.Body))
+	}
+
+	msg := DeadLetterMessage{
+		MessageID:     d.MessageId,
+		Type:          d.Type,
+		CorrelationID: d.CorrelationId,
+		Attempts:      HeaderInt(d.Headers, HeaderAttempts),
+		Priority:      d.Priority,
+		Headers:       d.Headers,
+		Body:          body,
+	}
+	msg.OriginalQueue, _ = d.Headers[HeaderOriginalQueue].(string)
+	msg.Error, _ = d.Headers[HeaderError].(string)
+	msg.FailedAt, _ = d.Headers[HeaderFailedAt].(string)
+	return msg
+}
+
+func selected(ids []string, id string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"ussd-wrapper/library"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Envelope headers; message ID, type, occurred-at and correlation ID travel in the
+// AMQP properties, the W3C trace context (traceparent, tracestate) in the headers
+const (
+	HeaderSchemaVersion = "x-schema-version"
+	HeaderOccurredAt    = "x-occurred-at"
+	HeaderCausationID   = "x-causation-id"
+)
+
+// SchemaVersion is the envelope version this build publishes. Consumers accept
+// versions up to it; messages from before envelopes had no version and count as 1.
+const SchemaVersion = 1
+
+// ErrUnsupportedVersion is returned for messages of an envelope version this build cannot read
+var ErrUnsupportedVersion = errors.New("unsupported message schema version")
+
+// Envelope is the metadata every message carries. CorrelationID is shared by every
+// message that follows from the same original request; CausationID is the message ID
+// of the message whose handling published this one.
+type Envelope struct {
+	MessageID     string            `json:"message_id"`
+	Type          string            `json:"type"`
+	SchemaVersion int               `json:"schema_version"`
+	OccurredAt    time.Time         `json:"occurred_at"`
+	CorrelationID string            `json:"correlation_id"`
+	CausationID   string            `json:"causation_id,omitempty"`
+	Trace         map[string]string `json:"trace,omitempty"`
+}
+
+type envelopeKey struct{}
+
+// ContextWithEnvelope returns a context for handling the message of env;
+// messages published from it are correlated with and caused by that message
+func ContextWithEnvelope(ctx context.Context, env Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, env)
+}
+
+// EnvelopeFromContext returns the envelope of the message being handled in ctx
+func EnvelopeFromContext(ctx context.Context) (Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey{}).(Envelope)
+	return env, ok
+}
+
+// NewEnvelope creates the envelope of a message of messageType published from ctx,
+// capturing the trace context of ctx and the correlation of the message it handles
+func NewEnvelope(ctx context.Context, messageType string) Envelope {
+	env := Envelope{
+		MessageID:     uuid.NewString(),
+		Type:          messageType,
+		SchemaVersion: SchemaVersion,
+		OccurredAt:    time.Now().UTC(),
+		Trace:         make(map[string]string),
+	}
+	env.CorrelationID = env.MessageID
+
+	if parent, ok := EnvelopeFromContext(ctx); ok {
+		env.CausationID = parent.MessageID
+		if parent.CorrelationID != "" {
+			env.CorrelationID = parent.CorrelationID
+		}
+	}
+
+	for k, v := range library.InjectAMQPHeaders(ctx) {
+		if s, ok := v.(string); ok {
+			env.Trace[k] = s
+		}
+	}
+	return env
+}
+
+// publishing sets the envelope on a message
+func (env Envelope) publishing(msg amqp.Publishing) amqp.Publishing {
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	for k, v := range env.Trace {
+		msg.Headers[k] = v
+	}
+	msg.Headers[HeaderSchemaVersion] = int32(env.SchemaVersion)
+	msg.Headers[HeaderOccurredAt] = env.OccurredAt.UTC().Format(time.RFC3339Nano)
+	if env.CausationID != "" {
+		msg.Headers[HeaderCausationID] = env.CausationID
+	}
+
+	msg.MessageId = env.MessageID
+	msg.Type = env.Type
+	msg.Timestamp = env.OccurredAt
+	msg.CorrelationId = env.CorrelationID
+	return msg
+}
+
+// EnvelopeOf reads the envelope of a delivery, with ErrUnsupportedVersion when
+// it was published with a newer schema version than this build understands
+func EnvelopeOf(d amqp.Delivery) (Envelope, error) {
+	version := SchemaVersion
+	if _, ok := d.Headers[HeaderSchemaVersion]; ok {
+		version = HeaderInt(d.Headers, HeaderSchemaVersion)
+	}
+	if version < 1 || version > SchemaVersion {
+		return Envelope{}, fmt.Errorf("%w: %d, want at most %d", ErrUnsupportedVersion, version, SchemaVersion)
+	}
+
+	env := Envelope{
+		MessageID:     d.MessageId,
+		Type:          d.Type,
+		SchemaVersion: version,
+		OccurredAt:    d.Timestamp,
+		CorrelationID: d.CorrelationId,
+		Trace:         make(map[string]string),
+	}
+	if s, ok := d.Headers[HeaderOccurredAt].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			env.OccurredAt = t
+		}
+	}
+	if s, ok := d.Headers[HeaderCausationID].(string); ok {
+		env.CausationID = s
+	}
+	for _, k := range []string{"traceparent", "tracestate", "baggage"} {
+		if s, ok := d.Headers[k].(string); ok {
+			env.Trace[k] = s
+		}
+	}
+	return env, nil
+}
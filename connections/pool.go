@@ -0,0 +1,140 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrPublishNacked is returned when the broker refuses to take responsibility for a message
+	ErrPublishNacked = errors.New("message nacked by broker")
+
+	// ErrPublishTimeout is returned when the broker does not confirm a message in time
+	ErrPublishTimeout = errors.New("timed out waiting for broker confirm")
+)
+
+// publisherPool hands out long lived confirm mode channels. At most size
+// channels are in use at once; callers beyond that wait for one to be released.
+type publisherPool struct {
+	slots chan struct{}
+	idle  chan *amqp.Channel
+
+	confirmTimeout time.Duration
+
+	// queues whose topology has been declared on the current connection
+	declared sync.Map
+}
+
+func newPublisherPool(config Config) *publisherPool {
+	size := config.PublishChannels
+	if size < 1 {
+		size = 1
+	}
+	return &publisherPool{
+		slots:          make(chan struct{}, size),
+		idle:           make(chan *amqp.Channel, size),
+		confirmTimeout: time.Duration(config.ConfirmTimeout) * time.Second,
+	}
+}
+
+// acquire returns an idle channel or opens a new one once a slot is free
+func (c *RabbitMQClient) acquire(ctx context.Context) (*amqp.Channel, error) {
+	select {
+	case c.pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		select {
+		case ch := <-c.pool.idle:
+			if ch.IsClosed() {
+				continue
+			}
+			return ch, nil
+		default:
+		}
+
+		ch, err := c.openChannel()
+		if err == nil {
+			err = ch.Confirm(false)
+			if err != nil {
+				ch.Close()
+			}
+		}
+		if err != nil {
+			<-c.pool.slots
+			return nil, fmt.Errorf("failed to open publisher channel: %w", err)
+		}
+		return ch, nil
+	}
+}
+
+// release returns a channel to the pool, or closes it when it can no longer be trusted
+func (c *RabbitMQClient) release(ch *amqp.Channel, healthy bool) {
+	if healthy && !ch.IsClosed() {
+		c.pool.idle <- ch
+	} else {
+		ch.Close()
+	}
+	<-c.pool.slots
+}
+
+// resetTopology forgets declared topology so it is declared again on a new connection
+func (c *RabbitMQClient) resetTopology() {
+	c.pool.declared.Range(func(key, _ interface{}) bool {
+		c.pool.declared.Delete(key)
+		return true
+	})
+}
+
+// publishConfirmed publishes msg on a pooled channel and waits for the broker's confirm.
+// When declareQueue is set, its topology is declared first, once per connection.
+func (c *RabbitMQClient) publishConfirmed(ctx context.Context, exchange, routingKey, declareQueue string, msg amqp.Publishing) error {
+	ch, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	if declareQueue != "" {
+		if _, ok := c.pool.declared.Load(declareQueue); !ok {
+			if _, err := c.declareTopology(ch, declareQueue); err != nil {
+				// A failed declaration closes the channel on the broker side
+				c.release(ch, false)
+				return err
+			}
+			c.pool.declared.Store(declareQueue, struct{}{})
+			c.known.Store(declareQueue, struct{}{})
+		}
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, msg)
+	if err != nil {
+		c.release(ch, false)
+		return fmt.Errorf("error publishing message: %w", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, c.pool.confirmTimeout)
+	defer cancel()
+
+	acked, err := confirm.WaitContext(waitCtx)
+	if err != nil {
+		// The confirm may still arrive; do not reuse a channel with an outstanding one
+		c.release(ch, false)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %s", ErrPublishTimeout, c.pool.confirmTimeout)
+		}
+		return err
+	}
+
+	c.release(ch, true)
+	if !acked {
+		return ErrPublishNacked
+	}
+	return nil
+}
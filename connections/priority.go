@@ -0,0 +1,46 @@
+package connections
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Priority is the AMQP priority of a published message. It only has an effect on
+// queues declared with max_priority; the broker treats a priority above the queue's
+// maximum as the maximum, and ignores it altogether on queues without one.
+type Priority uint8
+
+// Named priority levels, lowest first. Queues that take prioritised messages
+// declare max_priority: 9 (MaxPriority) in the topology file.
+const (
+	PriorityBulk     Priority = 0 // marketing and other messages nobody is waiting for
+	PriorityNormal   Priority = 3 // default for transactional notifications
+	PriorityHigh     Priority = 6 // receipts of money movements
+	PriorityCritical Priority = 9 // OTPs and operational alerts
+
+	MaxPriority = PriorityCritical
+)
+
+var priorityNames = map[Priority]string{
+	PriorityBulk:     "bulk",
+	PriorityNormal:   "normal",
+	PriorityHigh:     "high",
+	PriorityCritical: "critical",
+}
+
+// ParsePriority returns the level named by s
+func ParsePriority(s string) (Priority, error) {
+	for p, name := range priorityNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q, want bulk, normal, high or critical", s)
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("priority(%d)", uint8(p))
+}
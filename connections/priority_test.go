@@ -0,0 +1,123 @@
+package connections
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []Priority{PriorityBulk, PriorityNormal, PriorityHigh, PriorityCritical} {
+		got, err := ParsePriority(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParsePriority(%q) = %v, %v; want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Error("ParsePriority(urgent) succeeded")
+	}
+}
+
+func TestPriorityLevelsAreOrdered(t *testing.T) {
+	levels := []Priority{PriorityBulk, PriorityNormal, PriorityHigh, PriorityCritical}
+	for i := 1; i < len(levels); i++ {
+		if levels[i] <= levels[i-1] {
+			t.Errorf("%v is not above %v", levels[i], levels[i-1])
+		}
+	}
+	if MaxPriority != levels[len(levels)-1] {
+		t.Errorf("MaxPriority = %v, want %v", MaxPriority, levels[len(levels)-1])
+	}
+}
+
+func TestQueueDeclaresMaxPriority(t *testing.T) {
+	args := QueueSpec{Name: "sms", MaxPriority: int(MaxPriority)}.arguments()
+	if got := args["x-max-priority"]; got != int(MaxPriority) {
+		t.Errorf("x-max-priority = %v, want %d", got, MaxPriority)
+	}
+	if args := (QueueSpec{Name: "plain"}).arguments(); args != nil {
+		t.Errorf("queue without max_priority has arguments %v", args)
+	}
+
+	invalid := &Topology{Queues: []QueueSpec{
+		{Name: "too_high", MaxPriority: 256},
+		{Name: "quorum", Type: "quorum", MaxPriority: 5},
+	}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate accepted invalid max_priority settings")
+	}
+}
+
+// TestPriorityOvertakesBacklog publishes a backlog of bulk messages followed by an OTP
+// and checks the OTP is delivered first. It needs a broker (rabbitmq_* environment)
+// and is skipped when none can be reached.
+func TestPriorityOvertakesBacklog(t *testing.T) {
+	if testing.Short() {
+		t.Skip("needs RabbitMQ")
+	}
+
+	client, err := NewRabbitMQClient(NewConfig())
+	if err != nil {
+		t.Skipf("RabbitMQ not reachable: %v", err)
+	}
+	defer client.Close()
+
+	queueName := fmt.Sprintf("priority_test.%d", time.Now().UnixNano())
+	err = client.UseTopology(&Topology{Queues: []QueueSpec{{Name: queueName, MaxPriority: int(MaxPriority)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ch, err := client.openChannel()
+		if err == nil {
+			ch.QueueDelete(queueName, false, false, false)
+			ch.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const backlog = 50
+	for i := 0; i < backlog; i++ {
+		if err := client.Publish(ctx, queueName, NewEnvelope(ctx, "test"), map[string]interface{}{"kind": "marketing", "n": i}, PriorityBulk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Publish(ctx, queueName, NewEnvelope(ctx, "test"), map[string]interface{}{"kind": "otp"}, PriorityCritical); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Publish(ctx, queueName, NewEnvelope(ctx, "test"), map[string]interface{}{"kind": "receipt"}, PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := client.Consume(ctx, queueName, "priority-test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	var kinds []string
+	for len(kinds) < 3 {
+		select {
+		case d := <-sub.Deliveries:
+			var body struct {
+				Kind string `json:"kind"`
+			}
+			json.Unmarshal(d.Body, &body)
+			kinds = append(kinds, body.Kind)
+			d.Ack(false)
+		case <-ctx.Done():
+			t.Fatalf("got %v before timing out", kinds)
+		}
+	}
+
+	want := []string{"otp", "receipt", "marketing"}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("delivery order %v, want %v first", kinds, want)
+		}
+	}
+}
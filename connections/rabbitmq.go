@@ -3,38 +3,58 @@ package connections
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
 	"os"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 	"ussd-wrapper/library/logger"
 )
 
 // RabbitMQClient provides a unified interface for both publishing and consuming
 type RabbitMQClient struct {
-	conn       *amqp.Connection
-	err        chan error
-	mu         sync.Mutex
-	reconnects int
-	maxRetries int
-	connected  bool
+	conn   *amqp.Connection
+	config Config
+	mu     sync.Mutex
+
+	// Connection state maintained by the supervisor; stateChanged is closed on every change
+	state        ConnectionState
+	stateChanged chan struct{}
+	reconnects   atomic.Int64
+
+	// Declarative layout from the topology file, nil for the legacy one exchange per queue layout
+	topology *Topology
+
+	// queues whose topology has been declared, redeclared after a reconnect
+	known sync.Map
 
 	// For tracking channels
 	channels     map[string]*amqp.Channel
 	channelMutex sync.Mutex
+
+	// Confirm mode channels for Publish
+	pool *publisherPool
 }
 
 // Config holds the RabbitMQ connection parameters
 type Config struct {
-	Host     string
-	User     string
-	Password string
-	Port     string
-	VHost    string
+	Host     string `mapstructure:"rabbitmq_host"`
+	User     string `mapstructure:"rabbitmq_user"`
+	Password string `mapstructure:"rabbitmq_pass"`
+	Port     string `mapstructure:"rabbitmq_port"`
+	VHost    string `mapstructure:"rabbitmq_vhost"`
+
+	PublishChannels     int `mapstructure:"rabbitmq_publish_channels"`      // confirm mode channels in use at once
+	ConfirmTimeout      int `mapstructure:"rabbitmq_confirm_timeout"`       // seconds to wait for a broker confirm
+	ReconnectBackoff    int `mapstructure:"rabbitmq_reconnect_backoff"`     // seconds before the first reconnect, doubled on every failure
+	ReconnectMaxBackoff int `mapstructure:"rabbitmq_reconnect_max_backoff"` // seconds
+
+	// Retry policy of consumed queues; the topology file overrides it per queue
+	MaxAttempts   int `mapstructure:"queue_max_attempts"`
+	RetryDelay    int `mapstructure:"queue_retry_delay"`     // seconds
+	RetryMaxDelay int `mapstructure:"queue_retry_max_delay"` // seconds
 }
 
 var (
@@ -48,7 +68,8 @@ var (
 	initialized bool
 )
 
-// NewConfig creates a Config from environment variables
+// NewConfig creates a Config from environment variables, for tools and tests
+// that run without the application config
 func NewConfig() Config {
 	return Config{
 		Host:     getEnvOrDefault("rabbitmq_host", "localhost"),
@@ -56,6 +77,14 @@ func NewConfig() Config {
 		Password: getEnvOrDefault("rabbitmq_pass", "guest"),
 		Port:     getEnvOrDefault("rabbitmq_port", "5672"),
 		VHost:    getEnvOrDefault("rabbitmq_vhost", ""),
+
+		PublishChannels:     8,
+		ConfirmTimeout:      5,
+		ReconnectBackoff:    1,
+		ReconnectMaxBackoff: 30,
+		MaxAttempts:         5,
+		RetryDelay:          5,
+		RetryMaxDelay:       300,
 	}
 }
 
@@ -69,7 +98,7 @@ func getEnvOrDefault(key, defaultValue string) string {
 
 // InitializeClient creates the shared RabbitMQ client instance
 // This should be called during application startup
-func InitializeClient(ctx context.Context) (*RabbitMQClient, error) {
+func InitializeClient(ctx context.Context, config Config) (*RabbitMQClient, error) {
 	clientMutex.Lock()
 	defer clientMutex.Unlock()
 
@@ -80,9 +109,6 @@ func InitializeClient(ctx context.Context) (*RabbitMQClient, error) {
 		return singleClient, nil
 	}
 
-	config := NewConfig()
-
-	log.Println("📦 Config created for new rmq client")
 	log.Printf("📡 RabbitMQ URI: amqp://%s:***@%s:%s%s\n", config.User, config.Host, config.Port, config.VHost)
 
 	client, err := InitializeClientWithConfig(ctx, config)
@@ -101,7 +127,7 @@ func InitializeClient(ctx context.Context) (*RabbitMQClient, error) {
 
 // InitializeClientWithConfig creates the shared RabbitMQ client with custom config
 func InitializeClientWithConfig(ctx context.Context, config Config) (*RabbitMQClient, error) {
-	amqpURI := fmt.Sprintf("amqp://%s:%s@%s:%d%s", config.User, config.Password, config.Host, config.Port, config.VHost)
+	amqpURI := fmt.Sprintf("amqp://%s:%s@%s:%s%s", config.User, config.Password, config.Host, config.Port, config.VHost)
 	logger.WithCtx(ctx).Printf("🔗 Dialing RabbitMQ at %s\n", strings.Replace(amqpURI, config.Password, "***", 1))
 
 	client, err := NewRabbitMQClient(config)
@@ -116,23 +142,14 @@ func InitializeClientWithConfig(ctx context.Context, config Config) (*RabbitMQCl
 	return client, nil
 }
 
+// NewRabbitMQClient connects to RabbitMQ and keeps the connection up for the life of the client
 func NewRabbitMQClient(config Config) (*RabbitMQClient, error) {
+	client := newRabbitMQClient(config)
 
-	connStr := fmt.Sprintf("amqp://%s:%s@%s:%s/%s",
-		config.User, config.Password, config.Host, config.Port, config.VHost)
-
-	conn, err := amqp.Dial(connStr)
-	if err != nil {
+	if err := client.Connect(config); err != nil {
 		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
-	client := &RabbitMQClient{
-		conn:      conn,
-		err:       make(chan error),
-		channels:  make(map[string]*amqp.Channel),
-		connected: true,
-	}
-
 	// Add both publisher and consumer channels
 	if err := client.AddChannel("publisher"); err != nil {
 		return nil, fmt.Errorf("failed to create publisher channel: %w", err)
@@ -145,6 +162,20 @@ func NewRabbitMQClient(config Config) (*RabbitMQClient, error) {
 	return client, nil
 }
 
+func newRabbitMQClient(config Config) *RabbitMQClient {
+	client := &RabbitMQClient{
+		config:       config,
+		state:        StateReconnecting,
+		stateChanged: make(chan struct{}),
+		channels:     make(map[string]*amqp.Channel),
+		pool:         newPublisherPool(config),
+	}
+	if err := client.registerMetrics(); err != nil {
+		log.Printf("failed to register RabbitMQ metrics: %v", err)
+	}
+	return client
+}
+
 func (c *RabbitMQClient) AddChannel(name string) error {
 	c.channelMutex.Lock()
 	defer c.channelMutex.Unlock()
@@ -195,11 +226,7 @@ func NewClient() (*RabbitMQClient, error) {
 
 // NewClientWithConfig creates a new RabbitMQ client with the specified config
 func NewClientWithConfig(config Config) (*RabbitMQClient, error) {
-	client := &RabbitMQClient{
-		err:        make(chan error),
-		maxRetries: 5,
-		channels:   make(map[string]*amqp.Channel),
-	}
+	client := newRabbitMQClient(config)
 
 	err := client.Connect(config)
 	if err != nil {
@@ -209,124 +236,74 @@ func NewClientWithConfig(config Config) (*RabbitMQClient, error) {
 	return client, nil
 }
 
-// Connect establishes a connection to RabbitMQ
+// Connect establishes a connection to RabbitMQ, redeclares known topology and
+// starts supervising it. Callers other than the constructors should not need it.
 func (c *RabbitMQClient) Connect(config Config) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	amqpURI := fmt.Sprintf("amqp://%s:%s@%s:%s/%s",
 		config.User, config.Password, config.Host, config.Port, config.VHost)
 
 	conn, err := amqp.Dial(amqpURI)
 
 	if err != nil {
-		return fmt.Errorf("error connecting to RabbitMQ with %s: %w", amqpURI, err)
+		return fmt.Errorf("error connecting to RabbitMQ at %s:%s: %w", config.Host, config.Port, err)
 	}
 
+	c.mu.Lock()
+	if c.state == StateClosed {
+		c.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("%w: client closed", ErrNotConnected)
+	}
 	c.conn = conn
-	c.connected = true
-	c.reconnects = 0
-
-	// Monitor connection for closure
-	go func() {
-		<-c.conn.NotifyClose(make(chan *amqp.Error))
-		c.connected = false
-		c.err <- errors.New("connection closed")
-	}()
-
-	return nil
-}
+	c.mu.Unlock()
 
-// Reconnect attempts to reconnect with exponential backoff
-func (c *RabbitMQClient) Reconnect() error {
-	if c.reconnects >= c.maxRetries {
-		return fmt.Errorf("max reconnection attempts (%d) reached", c.maxRetries)
+	c.resetTopology()
+	if err := c.redeclareTopology(); err != nil {
+		// Consume and Publish declare again on use, so this is not fatal
+		log.Printf("failed to redeclare RabbitMQ topology: %v", err)
 	}
 
-	config := NewConfig()
-
-	// Exponential backoff for reconnection attempts
-	backoff := time.Duration(1<<uint(c.reconnects)) * time.Second
-	log.Printf("Attempting to reconnect to RabbitMQ in %v (attempt %d/%d)",
-		backoff, c.reconnects+1, c.maxRetries)
+	c.setState(StateConnected)
 
-	time.Sleep(backoff)
-	c.reconnects++
+	// Monitor connection for closure
+	go c.supervise(conn)
 
-	return c.Connect(config)
+	return nil
 }
 
-// Publish sends a message to the specified exchange/queue
-func (c *RabbitMQClient) Publish(ctx context.Context, queueName string, payload interface{}, priority uint8) error {
-	// Check for connection errors and reconnect if necessary
-	select {
-	case err := <-c.err:
-		if err != nil {
-			if err := c.Reconnect(); err != nil {
-				return fmt.Errorf("failed to reconnect to RabbitMQ: %w", err)
-			}
-		}
-	default:
-		// Connection is ok, continue
-	}
-
-	if !c.connected {
-		if err := c.Reconnect(); err != nil {
-			return fmt.Errorf("failed to reconnect to RabbitMQ: %w", err)
-		}
+// redeclareTopology declares the topology of every queue used so far on the current connection
+func (c *RabbitMQClient) redeclareTopology() error {
+	var queues []string
+	c.known.Range(func(key, _ interface{}) bool {
+		queues = append(queues, key.(string))
+		return true
+	})
+	if len(queues) == 0 {
+		return nil
 	}
 
-	// Get a channel
-	c.mu.Lock()
-	ch, err := c.conn.Channel()
-	c.mu.Unlock()
-
+	ch, err := c.openChannel()
 	if err != nil {
-		return fmt.Errorf("error opening RabbitMQ channel: %w", err)
+		return err
 	}
 	defer ch.Close()
 
-	// Setup exchange and queue
-	exchange := queueName
-	routingKey := queueName
-	exchangeType := "direct"
-
-	err = ch.ExchangeDeclare(
-		exchange,     // name
-		exchangeType, // type
-		true,         // durable
-		false,        // auto-deleted
-		false,        // internal
-		false,        // no-wait
-		nil,          // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
-	}
-
-	// Create the queue
-	q, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	for _, queueName := range queues {
+		if _, err := c.declareTopology(ch, queueName); err != nil {
+			return err
+		}
+		c.pool.declared.Store(queueName, struct{}{})
 	}
+	return nil
+}
 
-	// Bind queue to exchange
-	err = ch.QueueBind(
-		q.Name,     // queue name
-		routingKey, // routing key
-		exchange,   // exchange
-		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to bind queue %s to exchange %s: %w", queueName, exchange, err)
+// Publish sends a message in env to the specified exchange/queue and returns once the
+// broker has confirmed it, or with ErrPublishNacked or ErrPublishTimeout.
+// The priority is ignored by queues declared without max_priority.
+func (c *RabbitMQClient) Publish(ctx context.Context, queueName string, env Envelope, payload interface{}, priority Priority) error {
+	// Fail fast while the supervisor reconnects; callers retry
+	if c.State() != StateConnected {
+		return ErrNotConnected
 	}
 
 	// Marshal the payload
@@ -335,106 +312,68 @@ func (c *RabbitMQClient) Publish(ctx context.Context, queueName string, payload
 		return fmt.Errorf("error marshaling payload to JSON: %w", err)
 	}
 
-	// Publish the message
-	err = ch.PublishWithContext(
-		ctx,
-		exchange,   // exchange
-		routingKey, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         message,
-			Priority:     priority,
-		})
+	// Publish on a pooled channel, declaring the queue's topology the first time it is used
+	return c.publishConfirmed(ctx, "", queueName, queueName, env.publishing(amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         message,
+		Priority:     uint8(priority),
+	}))
+}
 
-	if err != nil {
-		return fmt.Errorf("error publishing message: %w", err)
-	}
+// Subscription is a consumer registered on its own channel. Deliveries are settled
+// by the caller; the channel stays open after Cancel so in-flight messages can still be acked.
+type Subscription struct {
+	Deliveries <-chan amqp.Delivery
 
-	return nil
+	ch  *amqp.Channel
+	tag string
 }
 
-// Consume creates a consumer for the specified queue
-func (c *RabbitMQClient) Consume(ctx context.Context, queueName string, consumerName string) (<-chan amqp.Delivery, error) {
-	// Check for connection errors and reconnect if necessary
-	select {
-	case err := <-c.err:
-		if err != nil {
-			if err := c.Reconnect(); err != nil {
-				return nil, fmt.Errorf("failed to reconnect to RabbitMQ: %w", err)
-			}
-		}
-	default:
-		// Connection is ok, continue
-	}
-
-	if !c.connected {
-		if err := c.Reconnect(); err != nil {
-			return nil, fmt.Errorf("failed to reconnect to RabbitMQ: %w", err)
-		}
-	}
+// Cancel stops the broker from sending more deliveries. Deliveries closes once
+// the ones already sent have been received.
+func (s *Subscription) Cancel() error {
+	return s.ch.Cancel(s.tag, false)
+}
 
-	// Get a channel
-	c.mu.Lock()
-	ch, err := c.conn.Channel()
-	c.mu.Unlock()
+// Close closes the channel; the broker requeues every delivery that was not settled
+func (s *Subscription) Close() error {
+	return s.ch.Close()
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error opening RabbitMQ channel: %w", err)
+// Consume creates a consumer for the specified queue that has at most prefetch unacked messages.
+// The delivery channel closes when the connection drops; wait with WaitConnected and consume again.
+func (c *RabbitMQClient) Consume(ctx context.Context, queueName string, consumerName string, prefetch int) (*Subscription, error) {
+	if c.State() != StateConnected {
+		return nil, ErrNotConnected
 	}
 
-	// Setup exchange and queue
-	exchange := queueName
-	routingKey := queueName
-	exchangeType := "direct"
-
-	err = ch.ExchangeDeclare(
-		exchange,     // name
-		exchangeType, // type
-		true,         // durable
-		false,        // auto-deleted
-		false,        // internal
-		false,        // no-wait
-		nil,          // arguments
-	)
+	// Get a channel
+	ch, err := c.openChannel()
 	if err != nil {
-		return nil, fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+		return nil, err
 	}
 
-	// Create the queue
-	q, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
+	// Setup exchange, queue, retry queues and DLQ
+	q, err := c.declareTopology(ch, queueName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+		ch.Close()
+		return nil, err
 	}
+	c.known.Store(queueName, struct{}{})
 
-	// Bind queue to exchange
-	err = ch.QueueBind(
-		q.Name,     // queue name
-		routingKey, // routing key
-		exchange,   // exchange
-		false,
-		nil,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to bind queue %s to exchange %s: %w", queueName, exchange, err)
+	if prefetch < 1 {
+		prefetch = 1
 	}
 
 	// Set prefetch count (QoS)
 	err = ch.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		prefetch, // prefetch count
+		0,        // prefetch size
+		false,    // global
 	)
 	if err != nil {
+		ch.Close()
 		return nil, fmt.Errorf("failed to set QoS: %w", err)
 	}
 
@@ -449,20 +388,17 @@ func (c *RabbitMQClient) Consume(ctx context.Context, queueName string, consumer
 		nil,          // args
 	)
 	if err != nil {
+		ch.Close()
 		return nil, fmt.Errorf("failed to register consumer: %w", err)
 	}
 
-	// We need to handle closing the channel when the context is done
-	go func() {
-		<-ctx.Done()
-		ch.Close()
-	}()
-
-	return deliveries, nil
+	return &Subscription{Deliveries: deliveries, ch: ch, tag: consumerName}, nil
 }
 
-// Close closes the RabbitMQ connection
+// Close closes the RabbitMQ connection and stops the supervisor
 func (c *RabbitMQClient) Close() error {
+	c.setState(StateClosed)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
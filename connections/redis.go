@@ -4,39 +4,33 @@ import (
 	"context"
 	"fmt"
 	"github.com/go-redis/redis"
-	"os"
-	"strconv"
 	"time"
 	"ussd-wrapper/library/logger"
 )
 
-func InitRedis(ctx context.Context) *redis.Client {
-
-	host := os.Getenv("redis_host")
-	port := os.Getenv("redis_port")
-	db := os.Getenv("redis_database_number")
-	auth := os.Getenv("redis_password")
-
-	dbNumber, err := strconv.Atoi(db)
-	if err != nil {
+// RedisConfig holds the Redis connection settings
+type RedisConfig struct {
+	Host     string `mapstructure:"redis_host"`
+	Port     int    `mapstructure:"redis_port"`
+	Password string `mapstructure:"redis_password"`
+	DB       int    `mapstructure:"redis_database_number"`
+}
 
-		dbNumber = 1
-	}
+func InitRedis(ctx context.Context, config RedisConfig) *redis.Client {
 
-	uri := fmt.Sprintf("redis://%s:%s", host, port)
-	uri = fmt.Sprintf("%s:%s", host, port)
+	uri := fmt.Sprintf("%s:%d", config.Host, config.Port)
 
 	opts := redis.Options{
 		MinIdleConns: 10,
 		IdleTimeout:  60 * time.Second,
 		PoolSize:     1000,
 		Addr:         uri,
-		DB:           dbNumber, // use default DB
+		DB:           config.DB,
 	}
 
-	if len(auth) > 0 {
+	if len(config.Password) > 0 {
 
-		opts.Password = auth
+		opts.Password = config.Password
 	}
 
 	client := redis.NewClient(&opts)
@@ -0,0 +1,151 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+	"ussd-wrapper/library/logger"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ConnectionState is the state of the RabbitMQ connection as seen by the supervisor
+type ConnectionState string
+
+const (
+	StateConnected    ConnectionState = "connected"
+	StateReconnecting ConnectionState = "reconnecting"
+	StateClosed       ConnectionState = "closed"
+)
+
+// ErrNotConnected is returned by Publish and Consume while the supervisor is reconnecting
+var ErrNotConnected = errors.New("rabbitmq is not connected")
+
+// State returns the current connection state
+func (c *RabbitMQClient) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Reconnects returns how often the connection has been re-established
+func (c *RabbitMQClient) Reconnects() int64 {
+	return c.reconnects.Load()
+}
+
+// WaitConnected blocks until the connection is up, ctx is done or the client is closed
+func (c *RabbitMQClient) WaitConnected(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		state, changed := c.state, c.stateChanged
+		c.mu.Unlock()
+
+		switch state {
+		case StateConnected:
+			return nil
+		case StateClosed:
+			return fmt.Errorf("%w: client closed", ErrNotConnected)
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setState records a state change and wakes everyone waiting in WaitConnected
+func (c *RabbitMQClient) setState(state ConnectionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == state {
+		return
+	}
+	c.state = state
+	close(c.stateChanged)
+	c.stateChanged = make(chan struct{})
+}
+
+// supervise waits for conn to close and re-dials with jittered exponential backoff,
+// without limit, until it succeeds or the client is closed. Consumers resubscribe
+// through WaitConnected and Consume; publishers redeclare topology on first use.
+func (c *RabbitMQClient) supervise(conn *amqp.Connection) {
+	closeErr, ok := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+	if c.State() == StateClosed {
+		return
+	}
+	if !ok {
+		closeErr = &amqp.Error{Reason: "connection closed"}
+	}
+
+	c.setState(StateReconnecting)
+	log := logger.WithCtx(context.Background()).WithField("alert", true)
+	log.Errorf("RabbitMQ connection lost: %v", closeErr)
+
+	base := time.Duration(c.config.ReconnectBackoff) * time.Second
+	maxBackoff := time.Duration(c.config.ReconnectMaxBackoff) * time.Second
+
+	for attempt := 0; ; attempt++ {
+		backoff := base << uint(attempt)
+		if backoff > maxBackoff || backoff <= 0 {
+			backoff = maxBackoff
+		}
+		// Full jitter over the upper half keeps a fleet of instances from reconnecting in lockstep
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+		// Close changes the state, which cuts the wait short
+		c.mu.Lock()
+		changed := c.stateChanged
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(backoff):
+		}
+
+		if c.State() == StateClosed {
+			return
+		}
+
+		if err := c.Connect(c.config); err != nil {
+			log.Warnf("RabbitMQ reconnect attempt %d failed, retrying: %v", attempt+1, err)
+			continue
+		}
+
+		c.reconnects.Add(1)
+		logger.WithCtx(context.Background()).Infof("✅ RabbitMQ reconnected after %d attempts", attempt+1)
+		return
+	}
+}
+
+// registerMetrics exposes the connection state as rabbitmq.connected (1 or 0) and rabbitmq.reconnects
+func (c *RabbitMQClient) registerMetrics() error {
+	meter := otel.Meter("ussd-wrapper/connections")
+
+	connected, err := meter.Int64ObservableGauge("rabbitmq.connected",
+		metric.WithDescription("Whether the RabbitMQ connection is up"))
+	if err != nil {
+		return err
+	}
+	reconnects, err := meter.Int64ObservableCounter("rabbitmq.reconnects",
+		metric.WithDescription("Times the RabbitMQ connection was re-established"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		up := int64(0)
+		if c.State() == StateConnected {
+			up = 1
+		}
+		o.ObserveInt64(connected, up)
+		o.ObserveInt64(reconnects, c.Reconnects())
+		return nil
+	}, connected, reconnects)
+	return err
+}
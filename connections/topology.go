@@ -0,0 +1,270 @@
+package connections
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownQueue is returned when publishing to or consuming from a queue missing from the topology
+var ErrUnknownQueue = errors.New("queue not in topology")
+
+// Topology is the declarative RabbitMQ layout loaded from the topology file.
+// Every exchange, queue and binding is durable.
+type Topology struct {
+	Exchanges []ExchangeSpec `yaml:"exchanges"`
+	Queues    []QueueSpec    `yaml:"queues"`
+	Bindings  []BindingSpec  `yaml:"bindings"`
+}
+
+// ExchangeSpec declares an exchange
+type ExchangeSpec struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"` // direct, topic or fanout
+}
+
+// QueueSpec declares a queue. Consumed queues also get a DLQ and retry queues
+// (see RetryPolicy); the retry settings override the queue_* config defaults.
+type QueueSpec struct {
+	Name                 string                 `yaml:"name"`
+	Type                 string                 `yaml:"type"` // classic (default) or quorum
+	MaxPriority          int                    `yaml:"max_priority"`
+	MessageTTL           int64                  `yaml:"message_ttl"` // milliseconds
+	MaxLength            int64                  `yaml:"max_length"`
+	DeadLetterExchange   string                 `yaml:"dead_letter_exchange"`
+	DeadLetterRoutingKey string                 `yaml:"dead_letter_routing_key"`
+	Arguments            map[string]interface{} `yaml:"arguments"`
+
+	// Handler is the channel the consumer handler is registered under; queues without one are not consumed
+	Handler string `yaml:"handler"`
+
+	MaxAttempts   int `yaml:"max_attempts"`
+	RetryDelay    int `yaml:"retry_delay"`     // seconds
+	RetryMaxDelay int `yaml:"retry_max_delay"` // seconds
+
+	// Workers handle deliveries concurrently, with at most Prefetch unacked at once.
+	// With a PartitionKey (a top level field of the JSON body) messages sharing a key
+	// are handled in order by the same worker.
+	Workers      int    `yaml:"workers"`
+	Prefetch     int    `yaml:"prefetch"`
+	PartitionKey string `yaml:"partition_key"`
+
+	// Deduplicate is how redelivered messages are recognised by their message ID
+	Deduplicate Deduplication `yaml:"deduplicate"`
+}
+
+// Deduplication selects where a consumer remembers the messages it processed
+type Deduplication string
+
+const (
+	// DeduplicateRedis keeps message IDs in Redis for queue_dedup_ttl seconds (the default)
+	DeduplicateRedis Deduplication = "redis"
+	// DeduplicateDatabase also records them in processed_messages, in the handler's
+	// transaction when it calls inbox.Record; for handlers that move money
+	DeduplicateDatabase Deduplication = "database"
+	// DeduplicateNone handles every delivery
+	DeduplicateNone Deduplication = "none"
+)
+
+// BindingSpec binds a queue to an exchange
+type BindingSpec struct {
+	Exchange   string `yaml:"exchange"`
+	Queue      string `yaml:"queue"`
+	RoutingKey string `yaml:"routing_key"`
+}
+
+// LoadTopology reads and validates a topology file
+func LoadTopology(path string) (*Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topology file: %w", err)
+	}
+
+	var t Topology
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&t); err != nil {
+		return nil, fmt.Errorf("failed to parse topology file %s: %w", path, err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid topology file %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Validate checks names, types, arguments and references, reporting every problem at once
+func (t *Topology) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	exchanges := make(map[string]bool)
+	for i, e := range t.Exchanges {
+		switch {
+		case e.Name == "":
+			fail("exchange %d has no name", i+1)
+		case strings.HasPrefix(e.Name, "amq."):
+			fail("exchange %s uses the reserved amq. prefix", e.Name)
+		case exchanges[e.Name]:
+			fail("exchange %s is declared twice", e.Name)
+		}
+		switch e.Type {
+		case amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout:
+		default:
+			fail("exchange %s has type %q, want direct, topic or fanout", e.Name, e.Type)
+		}
+		exchanges[e.Name] = true
+	}
+
+	queues := make(map[string]bool)
+	for i, q := range t.Queues {
+		switch {
+		case q.Name == "":
+			fail("queue %d has no name", i+1)
+		case strings.HasPrefix(q.Name, "amq."):
+			fail("queue %s uses the reserved amq. prefix", q.Name)
+		case queues[q.Name]:
+			fail("queue %s is declared twice", q.Name)
+		}
+		queues[q.Name] = true
+
+		switch q.Type {
+		case "", amqp.QueueTypeClassic:
+			if q.MaxPriority < 0 || q.MaxPriority > 255 {
+				fail("queue %s max_priority must be between 0 and 255", q.Name)
+			}
+		case amqp.QueueTypeQuorum:
+			if q.MaxPriority != 0 {
+				fail("queue %s is a quorum queue and cannot set max_priority", q.Name)
+			}
+		default:
+			fail("queue %s has type %q, want classic or quorum", q.Name, q.Type)
+		}
+
+		if q.MessageTTL < 0 || q.MaxLength < 0 {
+			fail("queue %s message_ttl and max_length cannot be negative", q.Name)
+		}
+		if q.DeadLetterExchange != "" && !exchanges[q.DeadLetterExchange] {
+			fail("queue %s dead letters to undeclared exchange %s", q.Name, q.DeadLetterExchange)
+		}
+		if q.MaxAttempts < 0 || q.RetryDelay < 0 || q.RetryMaxDelay < 0 {
+			fail("queue %s retry settings cannot be negative", q.Name)
+		}
+		if q.Workers < 0 || q.Prefetch < 0 {
+			fail("queue %s workers and prefetch cannot be negative", q.Name)
+		} else if q.Prefetch > 0 && q.Prefetch < q.Workers {
+			fail("queue %s prefetch %d would leave some of its %d workers idle", q.Name, q.Prefetch, q.Workers)
+		}
+		switch q.Deduplicate {
+		case "", DeduplicateRedis, DeduplicateDatabase, DeduplicateNone:
+		default:
+			fail("queue %s has deduplicate %q, want redis, database or none", q.Name, q.Deduplicate)
+		}
+		if (q.Workers > 0 || q.Prefetch > 0 || q.PartitionKey != "" || q.Deduplicate != "") && q.Handler == "" {
+			fail("queue %s sets consumer settings but has no handler", q.Name)
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if !exchanges[b.Exchange] {
+			fail("binding of %s refers to undeclared exchange %s", b.Queue, b.Exchange)
+		}
+		if !queues[b.Queue] {
+			fail("binding on %s refers to undeclared queue %s", b.Exchange, b.Queue)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Queue returns the spec of a queue
+func (t *Topology) Queue(name string) (QueueSpec, bool) {
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return QueueSpec{}, false
+}
+
+// ConsumedQueues returns the queues that have a handler, in file order
+func (t *Topology) ConsumedQueues() []QueueSpec {
+	var consumed []QueueSpec
+	for _, q := range t.Queues {
+		if q.Handler != "" {
+			consumed = append(consumed, q)
+		}
+	}
+	return consumed
+}
+
+// Concurrency returns the worker pool size and prefetch of a consumed queue.
+// Both default to 1; prefetch defaults to the number of workers.
+func (q QueueSpec) Concurrency() (workers, prefetch int) {
+	workers, prefetch = q.Workers, q.Prefetch
+	if workers < 1 {
+		workers = 1
+	}
+	if prefetch < 1 {
+		prefetch = workers
+	}
+	return workers, prefetch
+}
+
+func (t *Topology) exchange(name string) ExchangeSpec {
+	for _, e := range t.Exchanges {
+		if e.Name == name {
+			return e
+		}
+	}
+	return ExchangeSpec{}
+}
+
+// arguments builds the x- arguments of a queue
+func (q QueueSpec) arguments() amqp.Table {
+	args := amqp.Table{}
+	for k, v := range q.Arguments {
+		args[k] = v
+	}
+	if q.Type != "" {
+		args[amqp.QueueTypeArg] = q.Type
+	}
+	if q.MaxPriority > 0 {
+		args["x-max-priority"] = q.MaxPriority
+	}
+	if q.MessageTTL > 0 {
+		args[amqp.QueueMessageTTLArg] = q.MessageTTL
+	}
+	if q.MaxLength > 0 {
+		args[amqp.QueueMaxLenArg] = q.MaxLength
+	}
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+// legacyTopology is used by clients without a topology file: a direct exchange
+// named after the queue, bound with the queue name as routing key
+func legacyTopology(queueName string) *Topology {
+	return &Topology{
+		Exchanges: []ExchangeSpec{{Name: queueName, Type: amqp.ExchangeDirect}},
+		Queues:    []QueueSpec{{Name: queueName, Handler: strings.SplitN(queueName, ".", 2)[0]}},
+		Bindings:  []BindingSpec{{Exchange: queueName, Queue: queueName, RoutingKey: queueName}},
+	}
+}
@@ -15,3 +15,10 @@ const (
 	RESPONSE_TYPE_CON = "CON" // Continues the session
 	RESPONSE_TYPE_END = "END" // Ends the session
 )
+
+// Queue channels owned by the USSD domain
+const (
+	SMSOutboundChannel   = "sms_outbound"
+	NotificationsChannel = "notifications"
+	ReconcileChannel     = "reconcile"
+)
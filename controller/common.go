@@ -7,25 +7,51 @@ import (
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel/trace"
 	"ussd-wrapper/connections"
+	"ussd-wrapper/library"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/settings"
+	"ussd-wrapper/wallet"
 )
 
+// Config holds the settings of the HTTP handlers
+type Config struct {
+	PaymentCallbackToken  string `mapstructure:"payment_callback_token"`  // required on payment and payout callbacks when set
+	SMSCallbackToken      string `mapstructure:"sms_callback_token"`      // required on SMS delivery reports when set
+	WithdrawalMaxAttempts int    `mapstructure:"withdrawal_max_attempts"` // code guesses per MSISDN every 15 minutes
+
+	// name:key entries, one per admin and per ATM or agent system; the name
+	// identifies the caller in reversal decisions and audit logs
+	AdminAPIKeys   []string `mapstructure:"admin_api_keys"`
+	PartnerAPIKeys []string `mapstructure:"partner_api_keys"`
+}
+
 // Controller holds all dependencies needed by controller handlers
 type Controller struct {
+	config     Config
 	db         *sql.DB
 	dbSlave    *sql.DB
 	redis      *redis.Client
 	rabbitConn *connections.RabbitMQClient
 	tracer     trace.Tracer
+	wallet     *wallet.Service
+	sms        *notifications.Worker
+	templates  *notifications.Templates
+	settings   *settings.Store
 }
 
 // NewController creates a new controller with all required dependencies
-func NewController(db *sql.DB, dbSlave *sql.DB, redis *redis.Client, rabbitConn *connections.RabbitMQClient, tracer trace.Tracer) *Controller {
+func NewController(config Config, db *sql.DB, dbSlave *sql.DB, redis *redis.Client, rabbitConn *connections.RabbitMQClient, tracer trace.Tracer, walletService *wallet.Service, smsWorker *notifications.Worker, templates *notifications.Templates, settingsStore *settings.Store) *Controller {
 	return &Controller{
+		config:     config,
 		db:         db,
 		dbSlave:    dbSlave,
 		redis:      redis,
 		tracer:     tracer,
 		rabbitConn: rabbitConn,
+		wallet:     walletService,
+		sms:        smsWorker,
+		templates:  templates,
+		settings:   settingsStore,
 	}
 }
 
@@ -49,7 +75,7 @@ func (ctl *Controller) RegisterRoutes1(e *echo.Echo) {
 // RegisterRoutes registers all application routes
 func (ctl *Controller) RegisterRoutes(e *echo.Echo) {
 	// Main USSD callback handler - all USSD interactions go through here
-	e.POST("/ussd/callback", ctl.HandleUSSD)
+	e.POST("/ussd/callback", ctl.HandleUSSD3, ctl.maintenance(ussdMaintenance))
 
 	// API routes with authentication middleware
 	//api := e.Group("/api", middleware.JWT([]byte(ctl.config.JWTSecret)))
@@ -81,11 +107,34 @@ func (ctl *Controller) RegisterRoutes(e *echo.Echo) {
 	reports.GET("/revenue", ctl.RevenueReport)
 	reports.GET("/audit-logs", ctl.AuditLogReport)*/
 
+	// Admin routes
+	admin := e.Group("/api/admin", library.APIKeyAuth(ctl.config.AdminAPIKeys))
+	admin.GET("/reversals", ctl.ListReversals)
+	admin.POST("/reversals/:id/approve", ctl.ApproveReversal)
+	admin.POST("/reversals/:id/reject", ctl.RejectReversal)
+	admin.GET("/sms", ctl.ListSMSMessages)
+	admin.GET("/sms/costs", ctl.SMSCostReport)
+	admin.GET("/templates", ctl.ListTemplates)
+	admin.POST("/templates", ctl.CreateTemplate)
+	admin.POST("/templates/preview", ctl.PreviewTemplate)
+	admin.POST("/templates/:id/activate", ctl.ActivateTemplate)
+	admin.GET("/dlq", ctl.ListDeadLetterQueues)
+	admin.GET("/dlq/:queue", ctl.ListDeadLetters)
+	admin.POST("/dlq/:queue/replay", ctl.ReplayDeadLetters)
+	admin.POST("/dlq/:queue/purge", ctl.PurgeDeadLetters)
+	admin.GET("/settings", ctl.ListSettings)
+	admin.PUT("/settings/:key", ctl.UpdateSetting)
+
+	// Partner routes for ATM and agent systems
+	partners := e.Group("/api/partners", library.APIKeyAuth(ctl.config.PartnerAPIKeys), ctl.maintenance(apiMaintenance))
+	partners.POST("/withdrawals/redeem", ctl.RedeemWithdrawal)
+
 	// Webhooks for external service callbacks
-	/*hooks := e.Group("/webhooks")
+	hooks := e.Group("/webhooks")
 	hooks.POST("/payment-notification", ctl.PaymentNotification)
-	hooks.POST("/sms-delivery", ctl.SMSDeliveryStatus)*/
+	hooks.POST("/payout-result", ctl.PayoutResult)
+	hooks.POST("/sms-delivery", ctl.SMSDeliveryStatus)
 
 	// Health check
-	//e.GET("/health", ctl.HealthCheck)
+	e.GET("/health", ctl.HealthCheck)
 }
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+type deadLetterSelection struct {
+	MessageIDs []string `json:"message_ids"`
+	All        bool     `json:"all"`
+}
+
+// ListDeadLetterQueues returns the number of dead-lettered messages of every consumed queue
+func (ctl *Controller) ListDeadLetterQueues(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	queues := []echo.Map{}
+	for _, spec := range ctl.consumedQueues() {
+		queue := spec.Name
+		count, err := ctl.rabbitConn.DeadLetterCount(queue)
+		if err != nil {
+			logger.WithCtx(ctx).Errorf("Failed to inspect dead letter queue of %s: %v", queue, err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+		}
+		queues = append(queues, echo.Map{"queue": queue, "messages": count})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: queues})
+}
+
+// ListDeadLetters returns up to limit messages of a queue's DLQ with their headers and errors
+func (ctl *Controller) ListDeadLetters(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	queue, ok := ctl.deadLetterQueueParam(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "unknown queue"})
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	messages, err := ctl.rabbitConn.PeekDeadLetters(ctx, queue, limit)
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to read dead letter queue of %s: %v", queue, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: messages})
+}
+
+// ReplayDeadLetters moves the selected messages, or all of them, back to the source queue
+func (ctl *Controller) ReplayDeadLetters(c echo.Context) error {
+	return ctl.handleDeadLetters(c, "dlq_replayed", func(ctx context.Context, queue string, ids []string) (echo.Map, error) {
+		replayed, err := ctl.rabbitConn.ReplayDeadLetters(ctx, queue, ids)
+		return echo.Map{"replayed": len(replayed), "message_ids": replayed}, err
+	})
+}
+
+// PurgeDeadLetters deletes the selected messages, or all of them, from a queue's DLQ
+func (ctl *Controller) PurgeDeadLetters(c echo.Context) error {
+	return ctl.handleDeadLetters(c, "dlq_purged", func(ctx context.Context, queue string, ids []string) (echo.Map, error) {
+		purged, err := ctl.rabbitConn.PurgeDeadLetters(ctx, queue, ids)
+		return echo.Map{"purged": purged}, err
+	})
+}
+
+// handleDeadLetters validates a replay or purge request, runs it and records it in the audit log.
+// Either message_ids or all must be given so an empty body cannot act on the whole queue.
+func (ctl *Controller) handleDeadLetters(c echo.Context, action string, run func(ctx context.Context, queue string, ids []string) (echo.Map, error)) error {
+	ctx := c.Request().Context()
+
+	admin := library.APIClient(c)
+
+	queue, ok := ctl.deadLetterQueueParam(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "unknown queue"})
+	}
+
+	var body deadLetterSelection
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+	if body.All == (len(body.MessageIDs) > 0) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "give either message_ids or all"})
+	}
+
+	result, err := run(ctx, queue, body.MessageIDs)
+	if err != nil {
+		// Part of the selection may have been handled already, so the audit entry is still written
+		logger.WithCtx(ctx).Errorf("Failed to %s for %s: %v", strings.ReplaceAll(action, "_", " "), queue, err)
+	}
+
+	entry := models.JSONMap{"admin": admin, "all": body.All, "message_ids": body.MessageIDs}
+	for k, v := range result {
+		entry[k] = v
+	}
+	auditErr := library.RecordAudit(ctx, ctl.db, models.AuditLog{
+		Action:     action,
+		EntityType: "dead_letter_queue",
+		EntityID:   queue,
+		NewValue:   entry,
+		IPAddress:  c.RealIP(),
+		UserAgent:  c.Request().UserAgent(),
+	})
+	if auditErr != nil {
+		logger.WithCtx(ctx).Errorf("Failed to audit %s for %s: %v", action, queue, auditErr)
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError, constants.DATA: result})
+	}
+
+	logger.WithCtx(ctx).Infof("%s by %s for %s: %v", action, admin, queue, result)
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: result})
+}
+
+// deadLetterQueueParam returns the queue named in the path if it is one of the consumed queues
+func (ctl *Controller) deadLetterQueueParam(c echo.Context) (string, bool) {
+	queue := c.Param("queue")
+	for _, spec := range ctl.consumedQueues() {
+		if spec.Name == queue {
+			return queue, true
+		}
+	}
+	return "", false
+}
+
+// consumedQueues returns the queues of the topology that have a DLQ
+func (ctl *Controller) consumedQueues() []connections.QueueSpec {
+	topology := ctl.rabbitConn.Topology()
+	if topology == nil {
+		return nil
+	}
+	return topology.ConsumedQueues()
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"net/http"
+	"ussd-wrapper/connections"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HealthCheck reports the state of the database, Redis and RabbitMQ connections.
+// It answers 503 while any of them is down, including while RabbitMQ reconnects.
+func (ctl *Controller) HealthCheck(c echo.Context) error {
+	ctx := c.Request().Context()
+	healthy := true
+
+	database := "up"
+	if err := ctl.db.PingContext(ctx); err != nil {
+		database, healthy = err.Error(), false
+	}
+
+	cache := "up"
+	if err := ctl.redis.Ping().Err(); err != nil {
+		cache, healthy = err.Error(), false
+	}
+
+	state := ctl.rabbitConn.State()
+	if state != connections.StateConnected {
+		healthy = false
+	}
+
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	return c.JSON(status, echo.Map{
+		"healthy":  healthy,
+		"database": database,
+		"redis":    cache,
+		"rabbitmq": echo.Map{
+			"state":      state,
+			"reconnects": ctl.rabbitConn.Reconnects(),
+		},
+	})
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+	"ussd-wrapper/wallet"
+
+	"github.com/labstack/echo/v4"
+)
+
+// handleReversalMenu lets a sender request the reversal of a mistaken transfer
+func (ctl *Controller) handleReversalMenu(ctx context.Context, session *models.USSDSession, currentInput string) (string, error) {
+	reversalStep, ok := session.Data["reversal_step"].(string)
+	if !ok {
+		reversalStep = "reference"
+		session.Data["reversal_step"] = reversalStep
+	}
+
+	switch reversalStep {
+	case "reference":
+		reference := strings.ToUpper(strings.TrimSpace(currentInput))
+		txn, err := ctl.wallet.GetReversibleTransaction(ctx, session.PhoneNumber, reference)
+		switch {
+		case errors.Is(err, wallet.ErrTransactionNotFound), errors.Is(err, wallet.ErrNotReversible):
+			return "CON Transaction not found or cannot be reversed. Enter the transaction reference:", nil
+		case errors.Is(err, wallet.ErrReversalWindowClosed):
+			return ctl.endReversal(ctx, session, "END This transaction is too old to be reversed.")
+		case errors.Is(err, wallet.ErrAccountNotFound):
+			return ctl.endReversal(ctx, session, "END No wallet is registered for this number.")
+		case err != nil:
+			return "", err
+		}
+
+		session.Data["reversal_reference"] = txn.ReferenceID
+		session.Data["reversal_step"] = "confirm"
+		if err := ctl.saveSession(ctx, session); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("CON Reverse %.2f sent to %s on %s?\n1. Confirm\n2. Cancel",
+			txn.Amount, txn.RecipientPhone, txn.CreatedAt.Format("02 Jan 15:04")), nil
+
+	case "confirm":
+		switch currentInput {
+		case "1":
+			reference := session.Data["reversal_reference"].(string)
+			_, err := ctl.wallet.RequestReversal(ctx, session.PhoneNumber, reference, "Requested via USSD")
+			switch {
+			case errors.Is(err, wallet.ErrReversalExists):
+				return ctl.endReversal(ctx, session, "END A reversal has already been requested for this transaction.")
+			case err != nil:
+				logger.WithCtx(ctx).Errorf("Reversal request failed for %s: %v", reference, err)
+				return ctl.endReversal(ctx, session, "END Unable to request reversal. Please try again later.")
+			}
+			return ctl.endReversal(ctx, session,
+				fmt.Sprintf("END Your reversal request for %s has been received. You will be notified by SMS once it is reviewed.", reference))
+
+		case "2":
+			return ctl.endReversal(ctx, session, "END Reversal cancelled.")
+
+		default:
+			return "CON Invalid option.\nConfirm reversal?\n1. Confirm\n2. Cancel", nil
+		}
+	}
+
+	// Should not reach here in normal flow
+	return ctl.getMainMenu()
+}
+
+// endReversal resets the reversal menu state and returns the final response
+func (ctl *Controller) endReversal(ctx context.Context, session *models.USSDSession, response string) (string, error) {
+	session.CurrentMenu = "main"
+	delete(session.Data, "reversal_step")
+	delete(session.Data, "reversal_reference")
+	if err := ctl.saveSession(ctx, session); err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// reversalDecision is the body of the approve and reject endpoints
+type reversalDecision struct {
+	Note string `json:"note"`
+}
+
+// ListReversals returns reversal requests, filtered by the status query parameter
+func (ctl *Controller) ListReversals(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	requests, err := ctl.wallet.ListReversalRequests(ctx, c.QueryParam("status"), limit)
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to list reversal requests: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: requests})
+}
+
+// ApproveReversal approves a reversal request (maker, then checker when maker-checker is on)
+func (ctl *Controller) ApproveReversal(c echo.Context) error {
+	return ctl.decideReversal(c, ctl.wallet.ApproveReversal)
+}
+
+// RejectReversal rejects a reversal request
+func (ctl *Controller) RejectReversal(c echo.Context) error {
+	return ctl.decideReversal(c, ctl.wallet.RejectReversal)
+}
+
+func (ctl *Controller) decideReversal(c echo.Context, decide func(ctx context.Context, id int64, admin, note string) (*models.ReversalRequest, error)) error {
+	ctx := c.Request().Context()
+
+	// The admin is whoever the API key belongs to, never a name the caller asserts
+	admin := library.APIClient(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid reversal request id"})
+	}
+
+	var body reversalDecision
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	req, err := decide(ctx, id, admin, body.Note)
+	switch {
+	case errors.Is(err, wallet.ErrReversalNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, wallet.ErrReversalClosed), errors.Is(err, wallet.ErrSameApprover):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case errors.Is(err, wallet.ErrInsufficientFunds):
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": "recipient no longer holds the funds"})
+	case err != nil:
+		logger.WithCtx(ctx).Errorf("Failed to process reversal request %d: %v", id, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: req})
+}
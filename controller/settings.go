@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/settings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type settingRequest struct {
+	Value string `json:"value"`
+}
+
+// ListSettings returns the runtime settings with their current values and where they come from
+func (ctl *Controller) ListSettings(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: ctl.settings.List()})
+}
+
+// UpdateSetting changes a runtime setting on every instance
+func (ctl *Controller) UpdateSetting(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	admin := library.APIClient(c)
+
+	var body settingRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	setting, err := ctl.settings.Set(ctx, c.Param("key"), body.Value, admin)
+	switch {
+	case errors.Is(err, settings.ErrUnknownSetting):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, settings.ErrInvalidValue):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case err != nil:
+		logger.WithCtx(ctx).Errorf("Failed to update setting %s: %v", c.Param("key"), err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: setting})
+}
+
+// maintenance answers with respond instead of handling requests while maintenance_mode is on
+func (ctl *Controller) maintenance(respond echo.HandlerFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if ctl.settings.Bool(settings.KeyMaintenanceMode) {
+				return respond(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// ussdMaintenance ends the USSD session, which the gateway expects to succeed
+func ussdMaintenance(c echo.Context) error {
+	return c.String(http.StatusOK, "END The service is under maintenance. Please try again later.")
+}
+
+// apiMaintenance tells API clients to retry later
+func apiMaintenance(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", "300")
+	return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": "service under maintenance"})
+}
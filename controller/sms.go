@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/notifications"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SMSDeliveryStatus receives delivery reports from the SMS gateway.
+// The reporting provider is given in the provider query parameter and defaults to the primary one.
+func (ctl *Controller) SMSDeliveryStatus(c echo.Context) error {
+	ctx, span := ctl.tracer.Start(c.Request().Context(), "SMSDeliveryStatus")
+	defer span.End()
+
+	token := ctl.config.SMSCallbackToken
+	if token != "" && c.QueryParam("token") != token {
+		logger.WithCtx(ctx).Warnf("rejected sms delivery report from %s: invalid token", c.RealIP())
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid callback token"})
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	report, err := ctl.sms.HandleDeliveryReport(ctx, c.QueryParam("provider"), form)
+	switch {
+	case errors.Is(err, notifications.ErrUnknownProvider), errors.Is(err, notifications.ErrInvalidDeliveryReport):
+		logger.WithCtx(ctx).Warnf("rejected sms delivery report: %v", err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, notifications.ErrMessageNotFound):
+		// Reports for superseded attempts land here; acknowledge so the gateway stops retrying
+		logger.WithCtx(ctx).Infof("sms delivery report for unknown message %s", form.Get("id"))
+		return c.JSON(http.StatusOK, echo.Map{"status": "ignored"})
+	case err != nil:
+		logger.WithCtx(ctx).Errorf("failed to process sms delivery report: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "callback not processed"})
+	}
+
+	logger.WithCtx(ctx).Infof("sms delivery report processed for %s: %s", report.MessageID, report.ProviderStatus)
+	return c.JSON(http.StatusOK, echo.Map{"status": "accepted"})
+}
+
+// ListSMSMessages returns the delivery status of messages by msisdn and/or reference
+func (ctl *Controller) ListSMSMessages(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	msisdn := c.QueryParam("msisdn")
+	reference := c.QueryParam("reference")
+	if msisdn == "" && reference == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "msisdn or reference is required"})
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	messages, err := notifications.ListMessages(ctx, ctl.dbSlave, msisdn, reference, limit)
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to list sms messages: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: messages})
+}
+
+// SMSCostReport totals SMS cost by provider and operator between from and to (YYYY-MM-DD, to exclusive).
+// Defaults to the last 30 days.
+func (ctl *Controller) SMSCostReport(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	to := time.Now().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -30)
+	var err error
+	if v := c.QueryParam("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "from must be YYYY-MM-DD"})
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "to must be YYYY-MM-DD"})
+		}
+	}
+
+	summaries, err := notifications.SummarizeCosts(ctx, ctl.dbSlave, from, to)
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to summarize sms costs: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: summaries})
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/notifications"
+
+	"github.com/labstack/echo/v4"
+)
+
+type templateRequest struct {
+	Key      string `json:"key"`
+	Language string `json:"language"`
+	Body     string `json:"body"`
+}
+
+type templatePreviewRequest struct {
+	Key      string                 `json:"key"`
+	Language string                 `json:"language"`
+	Body     string                 `json:"body"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// ListTemplates returns every template version, optionally filtered by key
+func (ctl *Controller) ListTemplates(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	templates, err := ctl.templates.List(ctx, c.QueryParam("key"))
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to list templates: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: templates, "placeholders": notifications.Placeholders})
+}
+
+// CreateTemplate stores a new version of a template and activates it
+func (ctl *Controller) CreateTemplate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	admin := library.APIClient(c)
+
+	var body templateRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	tmpl, err := ctl.templates.Create(ctx, strings.TrimSpace(body.Key), strings.ToLower(strings.TrimSpace(body.Language)), body.Body, admin)
+	if errors.Is(err, notifications.ErrInvalidTemplate) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to create template %s: %v", body.Key, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{constants.DATA: tmpl})
+}
+
+// ActivateTemplate makes an earlier version the active one
+func (ctl *Controller) ActivateTemplate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	admin := library.APIClient(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid template id"})
+	}
+
+	tmpl, err := ctl.templates.Activate(ctx, id, admin)
+	if errors.Is(err, notifications.ErrTemplateNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to activate template %d: %v", id, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: tmpl})
+}
+
+// PreviewTemplate renders either an unsaved body or the active version of a key.
+// Placeholders missing from data are filled with sample values.
+func (ctl *Controller) PreviewTemplate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var body templatePreviewRequest
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	data := ctl.templates.SampleData()
+	for k, v := range body.Data {
+		data[k] = v
+	}
+
+	var rendered string
+	var err error
+	switch {
+	case body.Body != "":
+		rendered, err = notifications.RenderBody(body.Body, data)
+	case body.Key != "":
+		rendered, err = ctl.templates.Render(ctx, body.Key, strings.ToLower(body.Language), data)
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "key or body is required"})
+	}
+
+	switch {
+	case errors.Is(err, notifications.ErrTemplateNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, notifications.ErrInvalidTemplate), errors.Is(err, notifications.ErrMissingPlaceholder):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case err != nil:
+		logger.WithCtx(ctx).Errorf("Failed to preview template %s: %v", body.Key, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{constants.DATA: echo.Map{"message": rendered, "length": len([]rune(rendered))}})
+}
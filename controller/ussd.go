@@ -41,7 +41,7 @@ func (ctl *Controller) HandleUSSD(c echo.Context) error {
 	return c.String(http.StatusOK, "CON USSD ready with context.")
 }
 
-// HandleUSSD processes all USSD requests
+// HandleUSSD3 processes all USSD requests; it serves /ussd/callback
 func (ctl *Controller) HandleUSSD3(c echo.Context) error {
 	sessionID := c.FormValue("sessionID")
 	serviceCode := c.FormValue("serviceCode")
@@ -99,6 +99,8 @@ func (ctl *Controller) processUSSDInput(ctx context.Context, session *models.USS
 		return ctl.handleDepositMenu(ctx, session, currentInput, inputs)
 	case "withdraw":
 		return ctl.handleWithdrawMenu(ctx, session, currentInput, inputs)
+	case "reversal":
+		return ctl.handleReversalMenu(ctx, session, currentInput)
 	default:
 		return ctl.getMainMenu()
 	}
@@ -148,7 +150,7 @@ func (ctl *Controller) getOrCreateSession(ctx context.Context, sessionID, phoneN
 
 // saveSession persists session data to Redis
 func (ctl *Controller) saveSession(ctx context.Context, session *models.USSDSession) error {
-	sessionKey := fmt.Sprintf("ussd:session:%s", session.ID)
+	sessionKey := fmt.Sprintf("ussd:session:%s", session.SessionID)
 	session.UpdatedAt = time.Now()
 
 	// Serialize session
@@ -185,7 +187,8 @@ func (ctl *Controller) getMainMenu() (string, error) {
 3. Deposit
 4. Withdraw
 5. Account Info
-6. Exit`
+6. Reverse Transaction
+7. Exit`
 	return menu, nil
 }
 
@@ -233,6 +236,14 @@ func (ctl *Controller) handleMainMenu(ctx context.Context, session *models.USSDS
 			accountInfo.Name, accountInfo.AccountNumber, accountInfo.Status), nil
 
 	case "6":
+		session.CurrentMenu = "reversal"
+		session.Data["reversal_step"] = "reference"
+		if err := ctl.saveSession(ctx, session); err != nil {
+			return "", err
+		}
+		return "CON Enter the reference of the transaction to reverse:", nil
+
+	case "7":
 		return "END Thank you for using our service.", nil
 
 	default:
@@ -2,12 +2,14 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 	"ussd-wrapper/library/logger"
 	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/wallet"
 )
 
 // getAccountInfo fetches account information for a phone number
@@ -112,13 +114,9 @@ func (ctl *Controller) handleTransferMenu(ctx context.Context, session *models.U
 			recipient := session.Data["transfer_recipient"].(string)
 			amount := session.Data["transfer_amount"].(float64)
 
-			// In production, call your payment API here
 			logger.WithCtx(ctx).Infof("Processing transfer: %f to %s from %s",
 				amount, recipient, session.PhoneNumber)
 
-			// Get recipient name (mock)
-			recipientName := "Jane Doe"
-
 			// Reset menu state
 			session.CurrentMenu = "main"
 			delete(session.Data, "transfer_step")
@@ -128,8 +126,40 @@ func (ctl *Controller) handleTransferMenu(ctx context.Context, session *models.U
 				return "", err
 			}
 
-			return fmt.Sprintf("END Transfer of %.2f to %s (%s) was successful.",
-				amount, recipient, recipientName), nil
+			txn, recipientUser, err := ctl.wallet.Transfer(ctx, session.PhoneNumber, recipient, amount)
+			switch {
+			case errors.Is(err, wallet.ErrAccountNotFound):
+				return "END No wallet is registered for this number.", nil
+			case errors.Is(err, wallet.ErrRecipientNotFound):
+				return "END The recipient does not have a wallet.", nil
+			case errors.Is(err, wallet.ErrSelfTransfer):
+				return "END You cannot transfer to your own account.", nil
+			case errors.Is(err, wallet.ErrInsufficientFunds):
+				return "END Insufficient funds.", nil
+			case err != nil:
+				logger.WithCtx(ctx).Errorf("Transfer failed for %s: %v", session.PhoneNumber, err)
+				return "END Unable to complete transfer. Please try again later.", nil
+			}
+
+			recipientName := strings.TrimSpace(recipientUser.FirstName + " " + recipientUser.LastName)
+
+			language := ""
+			if sender, err := ctl.wallet.GetUserByPhone(ctx, session.PhoneNumber); err == nil {
+				language = sender.Language
+			}
+			message, err := ctl.templates.Render(ctx, notifications.TemplateTransferSent, language, map[string]interface{}{
+				"amount":       amount,
+				"currency":     txn.Metadata["currency"],
+				"reference":    txn.ReferenceID,
+				"name":         recipientName,
+				"counterparty": recipient,
+			})
+			if err != nil {
+				logger.WithCtx(ctx).Errorf("Failed to render transfer receipt %s: %v", txn.ReferenceID, err)
+				return fmt.Sprintf("END Transfer successful.\nRef: %s", txn.ReferenceID), nil
+			}
+
+			return "END " + message, nil
 
 		} else if currentInput == "2" {
 			// Cancel transfer
@@ -207,6 +237,19 @@ func (ctl *Controller) handleDepositMenu(ctx context.Context, session *models.US
 
 		// Process deposit
 		amount := session.Data["deposit_amount"].(float64)
+
+		// Reset menu state
+		session.CurrentMenu = "main"
+		delete(session.Data, "deposit_step")
+		delete(session.Data, "deposit_amount")
+		if err := ctl.saveSession(ctx, session); err != nil {
+			return "", err
+		}
+
+		if method == "Mobile Money" {
+			return ctl.initiateMobileMoneyDeposit(ctx, session.PhoneNumber, amount)
+		}
+
 		accountInfo, err := ctl.getAccountInfo(ctx, session.PhoneNumber)
 		if err != nil {
 			return "", err
@@ -217,22 +260,12 @@ func (ctl *Controller) handleDepositMenu(ctx context.Context, session *models.US
 			amount, accountInfo.Currency, method)
 
 		switch method {
-		case "Mobile Money":
-			instructions += "1. Dial *100#\n2. Select Send Money\n3. Enter account: " + accountInfo.AccountNumber
 		case "Bank Transfer":
 			instructions += "Bank: Example Bank\nAccount: " + accountInfo.AccountNumber
 		case "Agent":
 			instructions += "Visit any of our agents with your ID and phone number."
 		}
 
-		// Reset menu state
-		session.CurrentMenu = "main"
-		delete(session.Data, "deposit_step")
-		delete(session.Data, "deposit_amount")
-		if err := ctl.saveSession(ctx, session); err != nil {
-			return "", err
-		}
-
 		return "END " + instructions, nil
 	}
 
@@ -240,6 +273,21 @@ func (ctl *Controller) handleDepositMenu(ctx context.Context, session *models.US
 	return ctl.getMainMenu()
 }
 
+// initiateMobileMoneyDeposit sends a payment prompt to the user's handset
+func (ctl *Controller) initiateMobileMoneyDeposit(ctx context.Context, phoneNumber string, amount float64) (string, error) {
+	txn, err := ctl.wallet.InitiateDeposit(ctx, phoneNumber, amount)
+	if errors.Is(err, wallet.ErrAccountNotFound) {
+		return "END No wallet is registered for this number.", nil
+	}
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Mobile money deposit failed for %s: %v", phoneNumber, err)
+		return "END Unable to initiate deposit. Please try again later.", nil
+	}
+
+	return fmt.Sprintf("END A payment request of %.2f %s has been sent to your phone. Enter your PIN to complete the deposit.\nRef: %s",
+		amount, txn.Metadata["currency"], txn.ReferenceID), nil
+}
+
 // handleWithdrawMenu processes withdrawal requests
 func (ctl *Controller) handleWithdrawMenu(ctx context.Context, session *models.USSDSession, currentInput string, allInputs []string) (string, error) {
 	withdrawStep, ok := session.Data["withdraw_step"].(string)
@@ -308,13 +356,6 @@ func (ctl *Controller) handleWithdrawMenu(ctx context.Context, session *models.U
 		// Process withdrawal
 		amount := session.Data["withdraw_amount"].(float64)
 
-		// In production, this would integrate with your payment system
-		// For now, generate a mock transaction ID
-		transactionID := fmt.Sprintf("WD%d", time.Now().Unix())
-
-		logger.WithCtx(ctx).Infof("Processing withdrawal: %f via %s for %s, txn: %s",
-			amount, method, session.PhoneNumber, transactionID)
-
 		// Reset menu state
 		session.CurrentMenu = "main"
 		delete(session.Data, "withdraw_step")
@@ -323,20 +364,59 @@ func (ctl *Controller) handleWithdrawMenu(ctx context.Context, session *models.U
 			return "", err
 		}
 
-		response := fmt.Sprintf("END Withdrawal of %.2f via %s initiated.\nTransaction ID: %s",
-			amount, method, transactionID)
-
-		if method == "ATM" {
-			response += "\nUse the ATM code: " + transactionID[2:]
+		switch method {
+		case "ATM":
+			return ctl.issueWithdrawalCode(ctx, session.PhoneNumber, amount, models.WithdrawalChannelATM)
+		case "Agent":
+			return ctl.issueWithdrawalCode(ctx, session.PhoneNumber, amount, models.WithdrawalChannelAgent)
 		}
 
-		return response, nil
+		return ctl.initiatePayout(ctx, session.PhoneNumber, amount)
 	}
 
 	// Should not reach here in normal flow
 	return ctl.getMainMenu()
 }
 
+// issueWithdrawalCode holds the amount and returns a one-time code for an ATM or agent
+func (ctl *Controller) issueWithdrawalCode(ctx context.Context, phoneNumber string, amount float64, channel models.WithdrawalChannel) (string, error) {
+	issued, err := ctl.wallet.IssueWithdrawalCode(ctx, phoneNumber, amount, channel)
+	switch {
+	case errors.Is(err, wallet.ErrAccountNotFound):
+		return "END No wallet is registered for this number.", nil
+	case errors.Is(err, wallet.ErrInsufficientFunds):
+		return "END Insufficient funds.", nil
+	case err != nil:
+		logger.WithCtx(ctx).Errorf("Withdrawal code issuance failed for %s: %v", phoneNumber, err)
+		return "END Unable to process withdrawal. Please try again later.", nil
+	}
+
+	where := "any ATM"
+	if channel == models.WithdrawalChannelAgent {
+		where = "any agent"
+	}
+
+	return fmt.Sprintf("END Withdrawal code: %s\nAmount: %.2f\nUse it at %s before %s.\nRef: %s",
+		issued.Code, issued.Amount, where, issued.ExpiresAt.Format("15:04"), issued.Reference), nil
+}
+
+// initiatePayout sends the amount from the wallet to the user's mobile money account
+func (ctl *Controller) initiatePayout(ctx context.Context, phoneNumber string, amount float64) (string, error) {
+	txn, err := ctl.wallet.InitiatePayout(ctx, phoneNumber, amount)
+	switch {
+	case errors.Is(err, wallet.ErrAccountNotFound):
+		return "END No wallet is registered for this number.", nil
+	case errors.Is(err, wallet.ErrInsufficientFunds):
+		return "END Insufficient funds.", nil
+	case err != nil:
+		logger.WithCtx(ctx).Errorf("Mobile money withdrawal failed for %s: %v", phoneNumber, err)
+		return "END Unable to process withdrawal. Please try again later.", nil
+	}
+
+	return fmt.Sprintf("END Withdrawal of %.2f %s to mobile money initiated. You will receive a confirmation shortly.\nRef: %s",
+		amount, txn.Metadata["currency"], txn.ReferenceID), nil
+}
+
 // isValidPhoneNumber validates a phone number format
 func (ctl *Controller) isValidPhoneNumber(phone string) bool {
 	// Basic validation - you would enhance this based on your requirements
@@ -1 +1,79 @@
 package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/payments"
+	"ussd-wrapper/wallet"
+
+	"github.com/labstack/echo/v4"
+)
+
+// validCallbackToken reports whether a provider callback carries payment_callback_token.
+// Callbacks are refused while no token is configured.
+func (ctl *Controller) validCallbackToken(c echo.Context) bool {
+	token := ctl.config.PaymentCallbackToken
+	got := c.Request().Header.Get(payments.CallbackTokenHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// PaymentNotification receives deposit results from the mobile money provider
+func (ctl *Controller) PaymentNotification(c echo.Context) error {
+	ctx, span := ctl.tracer.Start(c.Request().Context(), "PaymentNotification")
+	defer span.End()
+
+	if !ctl.validCallbackToken(c) {
+		logger.WithCtx(ctx).Warnf("rejected payment callback from %s: invalid token", c.RealIP())
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid callback token"})
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	result, err := ctl.wallet.HandleDepositCallback(ctx, body)
+	if errors.Is(err, wallet.ErrTransactionNotFound) {
+		logger.WithCtx(ctx).Warnf("payment callback for unknown transaction: %s", string(body))
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "unknown reference"})
+	}
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("failed to process payment callback: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "callback not processed"})
+	}
+
+	logger.WithCtx(ctx).Infof("payment callback processed for %s: %s", result.Reference, result.Status)
+	return c.JSON(http.StatusOK, echo.Map{"status": "accepted"})
+}
+
+// PayoutResult receives B2C payout results from the payout provider
+func (ctl *Controller) PayoutResult(c echo.Context) error {
+	ctx, span := ctl.tracer.Start(c.Request().Context(), "PayoutResult")
+	defer span.End()
+
+	if !ctl.validCallbackToken(c) {
+		logger.WithCtx(ctx).Warnf("rejected payout callback from %s: invalid token", c.RealIP())
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid callback token"})
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	result, err := ctl.wallet.HandlePayoutCallback(ctx, body)
+	if errors.Is(err, wallet.ErrTransactionNotFound) {
+		logger.WithCtx(ctx).Warnf("payout callback for unknown transaction: %s", string(body))
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "unknown reference"})
+	}
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("failed to process payout callback: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "callback not processed"})
+	}
+
+	logger.WithCtx(ctx).Infof("payout callback processed for %s: %s", result.Reference, result.Status)
+	return c.JSON(http.StatusOK, echo.Map{"status": "accepted"})
+}
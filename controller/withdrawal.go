@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/wallet"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RedeemWithdrawal lets ATM and agent systems pay out a cardless withdrawal code
+func (ctl *Controller) RedeemWithdrawal(c echo.Context) error {
+	ctx, span := ctl.tracer.Start(c.Request().Context(), "RedeemWithdrawal")
+	defer span.End()
+
+	var req wallet.RedeemRequest
+	if err := c.Bind(&req); err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to parse redemption request: %v", err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid payload"})
+	}
+
+	req.MSISDN = strings.TrimSpace(req.MSISDN)
+	req.Code = strings.TrimSpace(req.Code)
+	if req.MSISDN == "" || req.Code == "" || req.TerminalID == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "msisdn, code and terminal_id are required"})
+	}
+
+	// Limit guesses per MSISDN so 8 digit codes cannot be brute forced; the count
+	// lapses 15 minutes after the last guess
+	attemptsKey := fmt.Sprintf("withdrawal:attempts:%s", req.MSISDN)
+	maxAttempts := int64(ctl.config.WithdrawalMaxAttempts)
+	attempts, err := library.IncRedisKeyWithExpiry(ctl.redis, attemptsKey, 15*60)
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("Failed to track redemption attempts: %v", err)
+		return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": "service temporarily unavailable"})
+	}
+	if attempts > maxAttempts {
+		return c.JSON(http.StatusTooManyRequests, echo.Map{"error": "too many attempts, try again later"})
+	}
+
+	token, err := ctl.wallet.RedeemWithdrawalCode(ctx, req)
+	switch {
+	case errors.Is(err, wallet.ErrInvalidCode):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, wallet.ErrCodeExpired):
+		return c.JSON(http.StatusGone, echo.Map{"error": err.Error()})
+	case errors.Is(err, wallet.ErrCodeRedeemed):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case errors.Is(err, wallet.ErrAmountMismatch), errors.Is(err, wallet.ErrChannelMismatch):
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
+	case err != nil:
+		logger.WithCtx(ctx).Errorf("Failed to redeem withdrawal code: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": constants.InternalServerError})
+	}
+
+	_ = library.DeleteRedisKey(ctx, ctl.redis, attemptsKey)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":      token.Status,
+		"amount":      token.Amount,
+		"msisdn":      token.MSISDN,
+		"channel":     token.Channel,
+		"redeemed_at": token.RedeemedAt,
+	})
+}
@@ -0,0 +1,105 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrDuplicate is returned by Record when the message being handled was already
+// processed; the handler should roll back its transaction
+var ErrDuplicate = errors.New("message already processed")
+
+type claim struct {
+	consumer  string
+	messageID string
+}
+
+type claimKey struct{}
+
+// WithClaim returns a context for handling messageID on behalf of consumer,
+// in which Record stores the message as processed
+func WithClaim(ctx context.Context, consumer, messageID string) context.Context {
+	return context.WithValue(ctx, claimKey{}, claim{consumer: consumer, messageID: messageID})
+}
+
+// Record marks the message handled in ctx as processed. Pass the *sql.Tx of the
+// handler's change so the mark is stored if and only if the change commits.
+// It does nothing when ctx is not handling a message, such as for webhook callbacks.
+func Record(ctx context.Context, db library.Execer) error {
+	c, ok := ctx.Value(claimKey{}).(claim)
+	if !ok {
+		return nil
+	}
+
+	_, err := db.ExecContext(ctx, "INSERT INTO processed_messages (consumer, message_id) VALUES (?, ?)", c.consumer, c.messageID)
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return fmt.Errorf("%w: %s on %s", ErrDuplicate, c.messageID, c.consumer)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record message %s as processed: %w", c.messageID, err)
+	}
+	return nil
+}
+
+// Processed reports whether consumer has processed messageID
+func Processed(ctx context.Context, db *sql.DB, consumer, messageID string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM processed_messages WHERE consumer = ? AND message_id = ?)", consumer, messageID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up message %s: %w", messageID, err)
+	}
+	return exists, nil
+}
+
+// MarkProcessed records messageID outside a transaction, for handlers that
+// finished without calling Record. Marking a message twice is not an error.
+func MarkProcessed(ctx context.Context, db library.Execer, consumer, messageID string) error {
+	_, err := db.ExecContext(ctx, "INSERT IGNORE INTO processed_messages (consumer, message_id) VALUES (?, ?)", consumer, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to mark message %s as processed: %w", messageID, err)
+	}
+	return nil
+}
+
+// Purge deletes marks older than the retention period and returns how many were deleted
+func Purge(ctx context.Context, db *sql.DB, retentionDays int) (int64, error) {
+	res, err := db.ExecContext(ctx,
+		"DELETE FROM processed_messages WHERE processed_at < CURRENT_TIMESTAMP(3) - INTERVAL ? DAY", retentionDays)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge processed messages: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Sweep purges marks older than retention days every hour until ctx is cancelled.
+// Redeliveries come within minutes, so the retention only has to outlive the longest retry schedule.
+func Sweep(ctx context.Context, db *sql.DB, retention int) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := Purge(ctx, db, retention)
+			if err != nil {
+				logger.WithCtx(ctx).Errorf("processed message sweep failed: %v", err)
+				continue
+			}
+			if purged > 0 {
+				logger.WithCtx(ctx).Infof("purged %d processed message marks older than %d days", purged, retention)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// execer fails every statement with err and counts them
+type execer struct {
+	err   error
+	calls int
+}
+
+func (e *execer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.calls++
+	return driver.RowsAffected(1), e.err
+}
+
+func TestRecord(t *testing.T) {
+	db := &execer{}
+	if err := Record(context.Background(), db); err != nil || db.calls != 0 {
+		t.Errorf("Record outside a consumer = %v after %d statements; want nothing recorded", err, db.calls)
+	}
+
+	ctx := WithClaim(context.Background(), "payouts", "msg-1")
+	if err := Record(ctx, db); err != nil || db.calls != 1 {
+		t.Errorf("Record = %v after %d statements; want the message recorded", err, db.calls)
+	}
+
+	db.err = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	if err := Record(ctx, db); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Record of a redelivery = %v, want %v", err, ErrDuplicate)
+	}
+
+	db.err = errors.New("connection reset")
+	if err := Record(ctx, db); err == nil || errors.Is(err, ErrDuplicate) {
+		t.Errorf("Record on a failing database = %v, want a plain error", err)
+	}
+}
@@ -0,0 +1,24 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"ussd-wrapper/models"
+)
+
+// Execer is satisfied by both *sql.DB and *sql.Tx so audit entries can join a transaction
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// RecordAudit writes an entry to audit_logs
+func RecordAudit(ctx context.Context, db Execer, entry models.AuditLog) error {
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO audit_logs (user_id, action, entity_type, entity_id, old_value, new_value, ip_address, user_agent) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.UserID, entry.Action, entry.EntityType, entry.EntityID, entry.OldValue, entry.NewValue, entry.IPAddress, entry.UserAgent)
+	if err != nil {
+		return fmt.Errorf("failed to record audit log %s: %w", entry.Action, err)
+	}
+	return nil
+}
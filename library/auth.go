@@ -0,0 +1,69 @@
+package library
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// APIKeyHeader is the header external systems use to authenticate (see @securityDefinitions in main.go)
+const APIKeyHeader = "api-key"
+
+// APIClientKey is the context key APIKeyAuth stores the authenticated client's name under
+const APIClientKey = "api_client"
+
+// ParseAPIKeys splits name:key entries into each client's key by name.
+// Names and keys must both be unique, so every key identifies one client.
+func ParseAPIKeys(entries []string) (map[string]string, error) {
+	keys := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("api key entries must be name:key, got %q", entry)
+		}
+		if _, ok := keys[name]; ok {
+			return nil, fmt.Errorf("api key name %q is used twice", name)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("the api key of %q is used twice", name)
+		}
+		keys[name] = key
+		seen[key] = true
+	}
+	return keys, nil
+}
+
+// APIKeyAuth returns a middleware that accepts requests carrying the key of one of
+// clients, given as name:key entries, and stores the client's name under APIClientKey.
+// The entries are validated at startup; malformed ones accept nothing.
+func APIKeyAuth(clients []string) echo.MiddlewareFunc {
+	keys, _ := ParseAPIKeys(clients)
+
+	return middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		KeyLookup: "header:" + APIKeyHeader,
+		Validator: func(key string, c echo.Context) (bool, error) {
+			for name, allowed := range keys {
+				if subtle.ConstantTimeCompare([]byte(key), []byte(allowed)) == 1 {
+					c.Set(APIClientKey, name)
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	})
+}
+
+// APIClient returns the name of the client APIKeyAuth authenticated the request as
+func APIClient(c echo.Context) string {
+	name, _ := c.Get(APIClientKey).(string)
+	return name
+}
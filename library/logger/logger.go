@@ -39,6 +39,11 @@ func Setup(logDir string) error {
 	return nil
 }
 
+// SetLevel sets the lowest level that is logged
+func SetLevel(level logrus.Level) {
+	log.SetLevel(level)
+}
+
 // NewLevelHook returns a logrus hook for a specific level with file rotation
 func NewLevelHook(level logrus.Level, path string) logrus.Hook {
 	writer := &lumberjack.Logger{
@@ -68,6 +68,22 @@ func IncRedisKey(conn *redis.Client, key string) (int64, error) {
 
 	return data, err
 }
+
+// IncRedisKeyWithExpiry increments key and sets its expiry in one MULTI, so the
+// counter cannot be left without one. Every increment restarts the expiry.
+func IncRedisKeyWithExpiry(conn *redis.Client, key string, seconds int) (int64, error) {
+
+	pipe := conn.TxPipeline()
+	incr := pipe.Incr(key)
+	pipe.Expire(key, time.Second*time.Duration(seconds))
+	if _, err := pipe.Exec(); err != nil {
+
+		return 0, fmt.Errorf("error incrementing key %s: %v", key, err)
+	}
+
+	return incr.Val(), nil
+}
+
 func DecRedisKey(conn *redis.Client, key string) (int64, error) {
 
 	var data int64
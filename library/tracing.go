@@ -2,43 +2,83 @@ package library
 
 import (
 	"context"
+	"errors"
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
+	"strings"
 )
 
-func InitTracer() (context.Context, error) {
-	ctx := context.Background()
+// TracingConfig holds the OTLP exporter settings
+type TracingConfig struct {
+	Enabled     bool   `mapstructure:"tracing_enabled"`
+	Endpoint    string `mapstructure:"otel_exporter_otlp_endpoint"` // URL, http:// exports without TLS
+	ServiceName string `mapstructure:"otel_service_name"`
+}
 
-	exporter, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpoint("otel-collector:4318"),
-		otlptracehttp.WithInsecure(),
-	)
+// InitTracer installs the OTLP tracer and meter providers and the W3C propagators.
+// With tracing disabled spans and metrics are not recorded, but trace context is
+// still propagated. Call ShutdownTracer before exiting so batched data is exported.
+func InitTracer(ctx context.Context, config TracingConfig) error {
+	// W3C trace context, carried over HTTP and in AMQP headers
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !config.Enabled {
+		return nil
+	}
+
+	// The endpoint is the collector's base URL; each signal has its own path under it
+	endpoint := strings.TrimRight(config.Endpoint, "/")
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint+"/v1/traces"))
+	if err != nil {
+		return err
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint+"/v1/metrics"))
 	if err != nil {
-		return ctx, err
+		return err
 	}
 
 	res, err := resource.New(ctx,
 		resource.WithAttributes(
-			semconv.ServiceName("ussd-wrapper"),
+			semconv.ServiceName(config.ServiceName),
 		),
 	)
 	if err != nil {
-		return ctx, err
+		return err
 	}
 
 	tracerProvider := sdktrace.NewTracerProvider(
 		sdktrace.WithBatcher(exporter),
 		sdktrace.WithResource(res),
 	)
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
 
 	otel.SetTracerProvider(tracerProvider)
-	return ctx, nil
+	otel.SetMeterProvider(meterProvider)
+	return nil
+}
+
+// ShutdownTracer exports the spans and metrics still buffered and stops the providers
+func ShutdownTracer(ctx context.Context) error {
+	var errs []error
+	if tracerProvider, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		errs = append(errs, tracerProvider.Shutdown(ctx))
+	}
+	if meterProvider, ok := otel.GetMeterProvider().(*sdkmetric.MeterProvider); ok {
+		errs = append(errs, meterProvider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
 }
 
 // TraceMiddleware injects a span into each request context
@@ -74,11 +114,9 @@ func SetupTracer() trace.Tracer {
 type AmqpHeadersCarrier map[string]interface{}
 
 func (a AmqpHeadersCarrier) Get(key string) string {
-	v, ok := a[key]
-	if !ok {
-		return ""
-	}
-	return v.(string)
+	// Other headers, like x-attempts, are not strings
+	v, _ := a[key].(string)
+	return v
 }
 
 func (a AmqpHeadersCarrier) Set(key string, value string) {
@@ -3,6 +3,7 @@ package library
 import (
 	"fmt"
 	"github.com/google/uuid"
+	"strings"
 	"time"
 )
 
@@ -33,10 +34,17 @@ func GenerateTransactionID(prefix string) string {
 	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
 	randomPart, _ := uuid.NewV7()
 
-	return fmt.Sprintf("%s%d%s", prefix, timestamp, randomPart)
+	// Strip the dashes so prefixed IDs still fit the 50 character reference_id column
+	return fmt.Sprintf("%s%d%s", prefix, timestamp, strings.ReplaceAll(randomPart.String(), "-", ""))
 }
 
 // FormatCurrency formats a number as currency with the given symbol
 func FormatCurrency(amount float64, currency string) string {
 	return fmt.Sprintf("%.2f %s", amount, currency)
 }
+
+// QueueName builds the full queue name for a channel the same way the queue manager does
+func QueueName(queues, channel string) string {
+	queue := strings.TrimSpace(strings.Split(queues, ",")[0])
+	return fmt.Sprintf("%s.%s", strings.ToLower(channel), strings.ToLower(queue))
+}
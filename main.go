@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"syscall"
 	"ussd-wrapper/docs"
 	"ussd-wrapper/library/logger"
 	"ussd-wrapper/router"
+
+	"github.com/sirupsen/logrus"
 )
 
 // @title USSD Wrapper Service API
@@ -28,29 +34,87 @@ import (
 
 func main() {
 
+	command, args := "all", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "all", "serve", "worker", "migrate", "seed":
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Every setting is checked before anything starts
+	config, err := router.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	docs.SwaggerInfo.Version = "3.0"
-	docs.SwaggerInfo.Host = os.Getenv("base_url")
+	docs.SwaggerInfo.Host = config.BaseURL
 	docs.SwaggerInfo.BasePath = "/"
-	docs.SwaggerInfo.Schemes = []string{os.Getenv("scheme")}
+	docs.SwaggerInfo.Schemes = []string{config.Scheme}
 
 	rootPath := GetRootPath()
 	// Ensure the logs directory exists
-	logDir := "logs"
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	if err := os.MkdirAll(config.LogDir, 0755); err != nil {
 		log.Fatalf("failed to create log directory: %v", err)
 	}
 
 	// Setup logger
-	if err := logger.Setup(logDir); err != nil {
+	if err := logger.Setup(config.LogDir); err != nil {
 		log.Fatalf("failed to set up logger: %v", err)
 	}
-
-	if err := router.Init(rootPath); err != nil {
-		log.Fatalf("Startup failed: %v", err)
+	level, _ := logrus.ParseLevel(config.LogLevel)
+	logger.SetLevel(level)
+	for _, warning := range config.Warnings() {
+		logrus.Warn(warning)
 	}
 
+	// SIGTERM from the orchestrator or Ctrl-C starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal kills the process without waiting for the shutdown
+		<-ctx.Done()
+		stop()
+	}()
+
+	switch command {
+	case "all":
+		err = router.Init(ctx, config, rootPath, router.ModeAll)
+	case "serve":
+		err = router.Init(ctx, config, rootPath, router.ModeServe)
+	case "worker":
+		err = router.Init(ctx, config, rootPath, router.ModeWorker)
+	case "migrate":
+		err = router.Migrate(ctx, config, rootPath, args)
+	case "seed":
+		err = router.Seed(ctx, config, rootPath)
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", command, err)
+	}
 }
 
+const usage = `usage: main [command]
+
+  all                       migrate up, then serve and run workers in one process (default)
+  serve                     serve the USSD front end and API; needs MySQL, Redis and RabbitMQ
+  worker                    consume queues and run the outbox relay, reconciler and sweeps,
+                            serving only /health on worker_port
+
+  serve, worker and all refuse to start on a dirty schema or one not at the latest migration
+  migrate up [N]            apply pending migrations; MySQL only
+                            --dry-run lists them without applying
+  migrate down [N]          roll back the last N migrations (default 1); prints the plan
+                            and only runs it with --yes
+  migrate status            list every migration as applied, pending or dirty
+  migrate force VERSION     mark VERSION applied and clean after fixing a failed migration
+  seed                      load the development data in seeds/
+`
+
 func GetRootPath() string {
 
 	_, b, _, _ := runtime.Caller(0)
@@ -0,0 +1,10 @@
+-- ====================
+-- Initial schema
+-- ====================
+-- Drops every table of the initial schema and all the data in them
+DROP TABLE IF EXISTS audit_logs;
+DROP TABLE IF EXISTS system_config;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS ussd_sessions;
+DROP TABLE IF EXISTS ussd_menus;
+DROP TABLE IF EXISTS users;
@@ -1,17 +1,13 @@
 -- ====================
--- Drop tables if they exist (safe rollback)
--- ====================
-DROP TABLE IF EXISTS transactions;
-DROP TABLE IF EXISTS ussd_sessions;
-DROP TABLE IF EXISTS audit_logs;
-DROP TABLE IF EXISTS system_config;
-DROP TABLE IF EXISTS ussd_menus;
-DROP TABLE IF EXISTS users;
+-- Tables are only created when missing: running this again against a database
+-- that has them fails on the indexes below instead of dropping data.
+-- Rolling back is 000001_initial_schema.down.sql.
+-- ====================
 
 -- ====================
 -- Users table
 -- ====================
-CREATE TABLE users
+CREATE TABLE IF NOT EXISTS users
 (
     id           BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     phone_number VARCHAR(20) UNIQUE NOT NULL,
@@ -27,7 +23,7 @@ CREATE TABLE users
 -- ====================
 -- Transactions table
 -- ====================
-CREATE TABLE transactions
+CREATE TABLE IF NOT EXISTS transactions
 (
     id               BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     reference_id     VARCHAR(50) UNIQUE                         NOT NULL,
@@ -48,7 +44,7 @@ CREATE TABLE transactions
 -- ====================
 -- USSD Sessions table
 -- ====================
-CREATE TABLE ussd_sessions
+CREATE TABLE IF NOT EXISTS ussd_sessions
 (
     id           BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     session_id   VARCHAR(100) UNIQUE NOT NULL,
@@ -63,7 +59,7 @@ CREATE TABLE ussd_sessions
 -- ====================
 -- Audit Logs table
 -- ====================
-CREATE TABLE audit_logs
+CREATE TABLE IF NOT EXISTS audit_logs
 (
     id          BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     user_id     BIGINT UNSIGNED,
@@ -81,7 +77,7 @@ CREATE TABLE audit_logs
 -- ====================
 -- System Config table
 -- ====================
-CREATE TABLE system_config
+CREATE TABLE IF NOT EXISTS system_config
 (
     setting_key VARCHAR(100) PRIMARY KEY,
     value       TEXT,
@@ -94,7 +90,7 @@ CREATE TABLE system_config
 -- ====================
 -- USSD Menus Configuration table
 -- ====================
-CREATE TABLE ussd_menus
+CREATE TABLE IF NOT EXISTS ussd_menus
 (
     id            BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     menu_key      VARCHAR(100) UNIQUE NOT NULL,
@@ -116,12 +112,3 @@ CREATE INDEX idx_ussd_sessions_session_id ON ussd_sessions(session_id);
 CREATE INDEX idx_ussd_sessions_phone_number ON ussd_sessions(phone_number);
 CREATE INDEX idx_audit_logs_user_id ON audit_logs(user_id);
 CREATE INDEX idx_audit_logs_entity_type_id ON audit_logs(entity_type, entity_id);
-
--- ====================
--- Optional Seed Data
--- ====================
-INSERT INTO users (phone_number, pin, first_name, last_name, balance)
-VALUES ('254700000001', 'hashed_pin_example', 'Jane', 'Doe', 500.00);
-
-INSERT INTO system_config (setting_key, value, description, updated_by)
-VALUES ('maintenance_mode', 'off', 'Enable or disable system-wide maintenance mode', 1);
@@ -0,0 +1,4 @@
+-- ====================
+-- Deposit tracking
+-- ====================
+DROP INDEX idx_transactions_type_status_created ON transactions;
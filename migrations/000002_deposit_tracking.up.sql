@@ -0,0 +1,4 @@
+-- ====================
+-- Pending transaction lookups (provider callback timeouts)
+-- ====================
+CREATE INDEX idx_transactions_type_status_created ON transactions(transaction_type, status, created_at);
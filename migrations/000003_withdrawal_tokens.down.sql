@@ -0,0 +1,8 @@
+-- ====================
+-- Cardless ATM / agent withdrawal codes
+-- ====================
+-- Funds still held for active codes are not released back to balance
+DROP TABLE IF EXISTS withdrawal_tokens;
+
+ALTER TABLE users
+    DROP COLUMN held_balance;
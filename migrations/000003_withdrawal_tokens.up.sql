@@ -0,0 +1,29 @@
+-- ====================
+-- Funds held against issued withdrawal codes
+-- ====================
+ALTER TABLE users
+    ADD COLUMN held_balance DECIMAL(20, 2) DEFAULT 0.00 AFTER balance;
+
+-- ====================
+-- Cardless ATM / agent withdrawal codes
+-- ====================
+CREATE TABLE withdrawal_tokens
+(
+    id              BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    code_hash       CHAR(64) UNIQUE                                   NOT NULL,
+    transaction_id  BIGINT UNSIGNED                                   NOT NULL,
+    user_id         BIGINT UNSIGNED                                   NOT NULL,
+    msisdn          VARCHAR(20)                                       NOT NULL,
+    amount          DECIMAL(20, 2)                                    NOT NULL,
+    channel         ENUM ('atm', 'agent')                             NOT NULL,
+    status          ENUM ('active', 'redeemed', 'expired')            NOT NULL DEFAULT 'active',
+    expires_at      TIMESTAMP                                         NOT NULL,
+    redeemed_at     TIMESTAMP                                         NULL,
+    redeemed_by     VARCHAR(100),
+    created_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (transaction_id) REFERENCES transactions (id),
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE INDEX idx_withdrawal_tokens_status_expires ON withdrawal_tokens(status, expires_at);
@@ -0,0 +1,4 @@
+-- ====================
+-- Internal ledger accounts (suspense, settlement)
+-- ====================
+DROP TABLE IF EXISTS system_accounts;
@@ -0,0 +1,14 @@
+-- ====================
+-- Internal ledger accounts (suspense, settlement)
+-- ====================
+CREATE TABLE system_accounts
+(
+    account_key VARCHAR(50) PRIMARY KEY,
+    balance     DECIMAL(20, 2) DEFAULT 0.00,
+    description TEXT,
+    created_at  TIMESTAMP      DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP      DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+INSERT INTO system_accounts (account_key, description)
+VALUES ('payout_suspense', 'Funds debited from wallets while a B2C payout is in flight');
@@ -0,0 +1,12 @@
+-- ====================
+-- Transaction reversals
+-- ====================
+DROP TABLE IF EXISTS reversal_requests;
+
+-- Fails while reversal or cancelled transactions exist, which the old enums cannot hold
+ALTER TABLE transactions
+    DROP FOREIGN KEY fk_transactions_reversal_of,
+    DROP INDEX uq_transactions_reversal_of,
+    DROP COLUMN reversal_of,
+    MODIFY transaction_type ENUM ('deposit', 'withdrawal', 'transfer') NOT NULL,
+    MODIFY status ENUM ('pending', 'completed', 'failed') NOT NULL;
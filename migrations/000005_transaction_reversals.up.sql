@@ -0,0 +1,33 @@
+-- ====================
+-- Align transaction enums with the application model and link compensating transactions
+-- ====================
+ALTER TABLE transactions
+    MODIFY transaction_type ENUM ('deposit', 'withdrawal', 'transfer', 'bill_payment', 'airtime', 'reversal') NOT NULL,
+    MODIFY status ENUM ('pending', 'completed', 'failed', 'cancelled') NOT NULL,
+    ADD COLUMN reversal_of BIGINT UNSIGNED NULL AFTER recipient_id,
+    ADD CONSTRAINT uq_transactions_reversal_of UNIQUE (reversal_of),
+    ADD CONSTRAINT fk_transactions_reversal_of FOREIGN KEY (reversal_of) REFERENCES transactions (id);
+
+-- ====================
+-- Reversal requests awaiting admin / maker-checker approval
+-- ====================
+CREATE TABLE reversal_requests
+(
+    id                      BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    transaction_id          BIGINT UNSIGNED                                          NOT NULL,
+    requested_by            BIGINT UNSIGNED                                          NOT NULL,
+    reason                  TEXT,
+    status                  ENUM ('pending', 'reviewed', 'approved', 'rejected')     NOT NULL DEFAULT 'pending',
+    reviewed_by             VARCHAR(100),
+    approved_by             VARCHAR(100),
+    review_note             TEXT,
+    reversal_transaction_id BIGINT UNSIGNED,
+    created_at              TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at              TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (transaction_id) REFERENCES transactions (id),
+    FOREIGN KEY (requested_by) REFERENCES users (id),
+    FOREIGN KEY (reversal_transaction_id) REFERENCES transactions (id)
+);
+
+CREATE INDEX idx_reversal_requests_status ON reversal_requests(status);
+CREATE INDEX idx_reversal_requests_transaction_id ON reversal_requests(transaction_id);
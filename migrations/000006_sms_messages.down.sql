@@ -0,0 +1,4 @@
+-- ====================
+-- Outbound SMS messages
+-- ====================
+DROP TABLE IF EXISTS sms_messages;
@@ -0,0 +1,22 @@
+-- ====================
+-- Outbound SMS messages
+-- ====================
+CREATE TABLE sms_messages
+(
+    id                  BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    reference           VARCHAR(100),
+    msisdn              VARCHAR(20)                                   NOT NULL,
+    message             TEXT                                          NOT NULL,
+    provider            VARCHAR(50),
+    provider_message_id VARCHAR(100),
+    status              ENUM ('queued', 'sent', 'delivered', 'failed') NOT NULL DEFAULT 'queued',
+    failure_reason      TEXT,
+    attempts            INT UNSIGNED DEFAULT 0,
+    sent_at             TIMESTAMP                                     NULL,
+    created_at          TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at          TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_sms_messages_msisdn ON sms_messages(msisdn);
+CREATE INDEX idx_sms_messages_reference ON sms_messages(reference);
+CREATE INDEX idx_sms_messages_provider_message_id ON sms_messages(provider_message_id);
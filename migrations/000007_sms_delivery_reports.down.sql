@@ -0,0 +1,6 @@
+-- ====================
+-- SMS delivery reports
+-- ====================
+ALTER TABLE sms_messages
+    DROP COLUMN provider_status,
+    DROP COLUMN delivered_at;
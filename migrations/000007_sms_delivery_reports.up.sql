@@ -0,0 +1,6 @@
+-- ====================
+-- SMS delivery report tracking
+-- ====================
+ALTER TABLE sms_messages
+    ADD COLUMN provider_status VARCHAR(50) AFTER status,
+    ADD COLUMN delivered_at TIMESTAMP NULL AFTER sent_at;
@@ -0,0 +1,15 @@
+-- ====================
+-- SMS routing rules and provider costs
+-- ====================
+DROP INDEX idx_sms_messages_created_provider ON sms_messages;
+
+ALTER TABLE sms_messages
+    DROP COLUMN route_id,
+    DROP COLUMN operator,
+    DROP COLUMN routing_reason,
+    DROP COLUMN cost,
+    DROP COLUMN cost_currency;
+
+DROP TABLE IF EXISTS sms_provider_costs;
+DROP TABLE IF EXISTS sms_routes;
+DROP TABLE IF EXISTS sms_operator_prefixes;
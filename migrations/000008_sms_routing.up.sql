@@ -0,0 +1,51 @@
+-- ====================
+-- SMS routing rules and provider costs
+-- ====================
+
+-- Identifies the mobile operator of an MSISDN by its longest matching prefix
+CREATE TABLE sms_operator_prefixes
+(
+    prefix       VARCHAR(15) PRIMARY KEY,
+    operator     VARCHAR(50) NOT NULL,
+    country_code VARCHAR(5)  NOT NULL
+);
+
+-- An empty prefix or operator matches every MSISDN; lower priority values are tried first
+CREATE TABLE sms_routes
+(
+    id          BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    prefix      VARCHAR(15) NOT NULL DEFAULT '',
+    operator    VARCHAR(50) NOT NULL DEFAULT '',
+    provider    VARCHAR(50) NOT NULL,
+    priority    INT         NOT NULL DEFAULT 100,
+    active      BOOLEAN              DEFAULT TRUE,
+    description VARCHAR(255),
+    created_at  TIMESTAMP            DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP            DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_sms_routes_active ON sms_routes(active, priority);
+
+-- Price of one message per provider, optionally narrowed by prefix or operator
+CREATE TABLE sms_provider_costs
+(
+    id         BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    provider   VARCHAR(50)    NOT NULL,
+    prefix     VARCHAR(15)    NOT NULL DEFAULT '',
+    operator   VARCHAR(50)    NOT NULL DEFAULT '',
+    cost       DECIMAL(10, 4) NOT NULL,
+    currency   CHAR(3)        NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_sms_provider_costs (provider, prefix, operator)
+);
+
+-- Routing decision of each message, for cost reporting
+ALTER TABLE sms_messages
+    ADD COLUMN route_id BIGINT UNSIGNED NULL AFTER provider_message_id,
+    ADD COLUMN operator VARCHAR(50) AFTER route_id,
+    ADD COLUMN routing_reason VARCHAR(255) AFTER operator,
+    ADD COLUMN cost DECIMAL(10, 4) NULL AFTER routing_reason,
+    ADD COLUMN cost_currency CHAR(3) AFTER cost;
+
+CREATE INDEX idx_sms_messages_created_provider ON sms_messages(created_at, provider);
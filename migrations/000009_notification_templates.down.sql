@@ -0,0 +1,7 @@
+-- ====================
+-- Notification templates
+-- ====================
+DROP TABLE IF EXISTS notification_templates;
+
+ALTER TABLE users
+    DROP COLUMN language;
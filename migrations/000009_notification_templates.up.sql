@@ -0,0 +1,47 @@
+-- ====================
+-- Notification templates
+-- ====================
+ALTER TABLE users
+    ADD COLUMN language VARCHAR(10) NOT NULL DEFAULT 'en' AFTER last_name;
+
+-- Every edit creates a new version; one version per key and language is active
+CREATE TABLE notification_templates
+(
+    id           BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    template_key VARCHAR(100) NOT NULL,
+    language     VARCHAR(10)  NOT NULL,
+    version      INT UNSIGNED NOT NULL,
+    body         TEXT         NOT NULL,
+    active       BOOLEAN   DEFAULT FALSE,
+    created_by   VARCHAR(100),
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_notification_templates_version (template_key, language, version)
+);
+
+CREATE INDEX idx_notification_templates_active ON notification_templates(template_key, language, active);
+
+INSERT INTO notification_templates (template_key, language, version, body, active, created_by)
+VALUES ('deposit_completed', 'en', 1, 'Confirmed. {{currency}} {{amount}} has been deposited to your wallet. Ref: {{reference}}', TRUE, 'system'),
+       ('deposit_failed', 'en', 1, 'Your deposit of {{currency}} {{amount}} could not be completed. Ref: {{reference}}', TRUE, 'system'),
+       ('withdrawal_code_issued', 'en', 1, 'Your withdrawal code is {{code}} for {{currency}} {{amount}} at any {{channel}}. It expires at {{expires_at}}. Ref: {{reference}}', TRUE, 'system'),
+       ('withdrawal_completed', 'en', 1, 'Confirmed. {{currency}} {{amount}} withdrawn at {{channel}}. Ref: {{reference}}', TRUE, 'system'),
+       ('withdrawal_code_expired', 'en', 1, 'Your withdrawal code for {{currency}} {{amount}} has expired and the funds are available in your wallet. Ref: {{reference}}', TRUE, 'system'),
+       ('payout_completed', 'en', 1, 'Confirmed. {{currency}} {{amount}} has been sent to your mobile money account {{msisdn}}. Ref: {{reference}}', TRUE, 'system'),
+       ('payout_failed', 'en', 1, 'Your withdrawal of {{currency}} {{amount}} to mobile money failed and has been refunded to your wallet. Ref: {{reference}}', TRUE, 'system'),
+       ('transfer_sent', 'en', 1, 'Confirmed. {{currency}} {{amount}} sent to {{name}} {{counterparty}}. Ref: {{reference}}', TRUE, 'system'),
+       ('transfer_received', 'en', 1, 'You have received {{currency}} {{amount}} from {{name}} {{counterparty}}. Ref: {{reference}}', TRUE, 'system'),
+       ('reversal_rejected', 'en', 1, 'Your request to reverse transaction {{reference}} was declined.', TRUE, 'system'),
+       ('reversal_refunded', 'en', 1, 'Transaction {{reference}} has been reversed. {{currency}} {{amount}} has been returned to your wallet.', TRUE, 'system'),
+       ('reversal_debited', 'en', 1, 'Transaction {{reference}} has been reversed. {{currency}} {{amount}} has been deducted from your wallet.', TRUE, 'system'),
+       ('deposit_completed', 'sw', 1, 'Imethibitishwa. {{currency}} {{amount}} imewekwa kwenye pochi yako. Kumb: {{reference}}', TRUE, 'system'),
+       ('deposit_failed', 'sw', 1, 'Amana yako ya {{currency}} {{amount}} haikukamilika. Kumb: {{reference}}', TRUE, 'system'),
+       ('withdrawal_code_issued', 'sw', 1, 'Nambari yako ya kutoa pesa ni {{code}} kwa {{currency}} {{amount}} kwenye {{channel}}. Itaisha {{expires_at}}. Kumb: {{reference}}', TRUE, 'system'),
+       ('withdrawal_completed', 'sw', 1, 'Imethibitishwa. {{currency}} {{amount}} imetolewa kwenye {{channel}}. Kumb: {{reference}}', TRUE, 'system'),
+       ('withdrawal_code_expired', 'sw', 1, 'Nambari yako ya kutoa {{currency}} {{amount}} imeisha muda na pesa zimerudishwa kwenye pochi yako. Kumb: {{reference}}', TRUE, 'system'),
+       ('payout_completed', 'sw', 1, 'Imethibitishwa. {{currency}} {{amount}} imetumwa kwa akaunti yako ya pesa ya simu {{msisdn}}. Kumb: {{reference}}', TRUE, 'system'),
+       ('payout_failed', 'sw', 1, 'Utoaji wako wa {{currency}} {{amount}} haukufaulu na pesa zimerudishwa kwenye pochi yako. Kumb: {{reference}}', TRUE, 'system'),
+       ('transfer_sent', 'sw', 1, 'Imethibitishwa. {{currency}} {{amount}} imetumwa kwa {{name}} {{counterparty}}. Kumb: {{reference}}', TRUE, 'system'),
+       ('transfer_received', 'sw', 1, 'Umepokea {{currency}} {{amount}} kutoka kwa {{name}} {{counterparty}}. Kumb: {{reference}}', TRUE, 'system'),
+       ('reversal_rejected', 'sw', 1, 'Ombi lako la kurejesha muamala {{reference}} limekataliwa.', TRUE, 'system'),
+       ('reversal_refunded', 'sw', 1, 'Muamala {{reference}} umerejeshwa. {{currency}} {{amount}} imerudishwa kwenye pochi yako.', TRUE, 'system'),
+       ('reversal_debited', 'sw', 1, 'Muamala {{reference}} umerejeshwa. {{currency}} {{amount}} imekatwa kwenye pochi yako.', TRUE, 'system');
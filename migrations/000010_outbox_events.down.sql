@@ -0,0 +1,5 @@
+-- ====================
+-- Transactional outbox
+-- ====================
+-- Pending events are lost; let the relay drain the outbox before rolling back
+DROP TABLE IF EXISTS outbox_events;
@@ -0,0 +1,23 @@
+-- ====================
+-- Transactional outbox
+-- ====================
+-- Rows are written in the same transaction as the business change and
+-- published to RabbitMQ by the relay worker
+CREATE TABLE outbox_events
+(
+    id              BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    aggregate_type  VARCHAR(50)                        NOT NULL,
+    aggregate_id    VARCHAR(100)                       NOT NULL,
+    event_type      VARCHAR(100)                       NOT NULL,
+    queue           VARCHAR(100)                       NOT NULL,
+    payload         JSON                               NOT NULL,
+    status          ENUM ('pending', 'sent', 'failed') NOT NULL DEFAULT 'pending',
+    attempts        INT UNSIGNED                                DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMP(3)                       NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    sent_at         TIMESTAMP(3)                       NULL,
+    created_at      TIMESTAMP(3)                       NOT NULL DEFAULT CURRENT_TIMESTAMP(3)
+);
+
+CREATE INDEX idx_outbox_events_pending ON outbox_events(status, next_attempt_at);
+CREATE INDEX idx_outbox_events_aggregate ON outbox_events(aggregate_type, aggregate_id, status, id);
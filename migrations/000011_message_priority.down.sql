@@ -0,0 +1,8 @@
+-- ================
+-- Message priority
+-- ================
+ALTER TABLE outbox_events
+    DROP COLUMN priority;
+
+ALTER TABLE sms_messages
+    DROP COLUMN priority;
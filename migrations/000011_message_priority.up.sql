@@ -0,0 +1,10 @@
+-- ================
+-- Message priority
+-- ================
+-- AMQP priority the relay publishes an event with (see connections.Priority)
+ALTER TABLE outbox_events
+    ADD COLUMN priority TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER payload;
+
+-- Kept so a resent message keeps the priority it was first sent with
+ALTER TABLE sms_messages
+    ADD COLUMN priority TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER message;
@@ -0,0 +1,5 @@
+-- ======================
+-- Outbox event envelope
+-- ======================
+ALTER TABLE outbox_events
+    DROP COLUMN envelope;
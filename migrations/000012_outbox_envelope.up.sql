@@ -0,0 +1,7 @@
+-- ======================
+-- Outbox event envelope
+-- ======================
+-- Message ID, correlation and trace context captured when the event is written,
+-- so the published message continues the trace of the request that caused it
+ALTER TABLE outbox_events
+    ADD COLUMN envelope JSON NULL AFTER priority;
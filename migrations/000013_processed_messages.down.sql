@@ -0,0 +1,4 @@
+-- ==================
+-- Processed messages
+-- ==================
+DROP TABLE IF EXISTS processed_messages;
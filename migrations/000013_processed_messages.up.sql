@@ -0,0 +1,14 @@
+-- ==================
+-- Processed messages
+-- ==================
+-- Message IDs handled by consumers whose handlers move money, written in the
+-- handler's transaction so a redelivered message cannot be applied twice
+CREATE TABLE processed_messages
+(
+    consumer     VARCHAR(100) NOT NULL,
+    message_id   VARCHAR(64)  NOT NULL,
+    processed_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    PRIMARY KEY (consumer, message_id)
+);
+
+CREATE INDEX idx_processed_messages_processed_at ON processed_messages(processed_at);
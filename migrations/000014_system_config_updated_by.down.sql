@@ -0,0 +1,11 @@
+-- ====================
+-- Runtime settings changed by admins
+-- ====================
+-- Admin names cannot reference users and are cleared
+UPDATE system_config
+SET updated_by = NULL
+WHERE updated_by NOT IN (SELECT CAST(id AS CHAR) FROM users);
+
+ALTER TABLE system_config
+    MODIFY updated_by BIGINT UNSIGNED,
+    ADD CONSTRAINT system_config_ibfk_1 FOREIGN KEY (updated_by) REFERENCES users (id) ON DELETE SET NULL;
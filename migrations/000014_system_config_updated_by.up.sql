@@ -0,0 +1,7 @@
+-- ====================
+-- Runtime settings changed by admins
+-- ====================
+-- updated_by names the admin or config file behind a change, like created_by of templates
+ALTER TABLE system_config
+    DROP FOREIGN KEY system_config_ibfk_1,
+    MODIFY updated_by VARCHAR(100);
@@ -0,0 +1,8 @@
+-- ===============
+-- Secret messages
+-- ===============
+ALTER TABLE outbox_events
+    DROP COLUMN secret;
+
+ALTER TABLE sms_messages
+    DROP COLUMN secret;
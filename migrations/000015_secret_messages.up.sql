@@ -0,0 +1,10 @@
+-- ===============
+-- Secret messages
+-- ===============
+-- Messages carrying a one-time secret, such as a withdrawal code, whose payload
+-- and text are purged once they are no longer needed to deliver them
+ALTER TABLE outbox_events
+    ADD COLUMN secret BOOLEAN NOT NULL DEFAULT FALSE AFTER envelope;
+
+ALTER TABLE sms_messages
+    ADD COLUMN secret BOOLEAN NOT NULL DEFAULT FALSE AFTER priority;
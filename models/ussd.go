@@ -64,6 +64,7 @@ const (
 	TransactionTypeTransfer   TransactionType = "transfer"
 	TransactionTypeBillPay    TransactionType = "bill_payment"
 	TransactionTypeAirtime    TransactionType = "airtime"
+	TransactionTypeReversal   TransactionType = "reversal"
 )
 
 // TransactionStatus defines the status of a transaction
@@ -83,6 +84,7 @@ type Transaction struct {
 	Type        TransactionType   `json:"transaction_type"`
 	SenderID    *int64            `json:"sender_id,omitempty"`
 	RecipientID *int64            `json:"recipient_id,omitempty"`
+	ReversalOf  *int64            `json:"reversal_of,omitempty"`
 	Amount      float64           `json:"amount"`
 	Fee         float64           `json:"fee"`
 	Status      TransactionStatus `json:"status"`
@@ -103,12 +105,119 @@ type User struct {
 	Pin         string    `json:"-"` // Never expose PIN in JSON
 	FirstName   string    `json:"first_name,omitempty"`
 	LastName    string    `json:"last_name,omitempty"`
+	Language    string    `json:"language"`
 	Balance     float64   `json:"balance"`
+	HeldBalance float64   `json:"held_balance"`
 	Status      string    `json:"status"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// WithdrawalChannel defines where a withdrawal code can be redeemed
+type WithdrawalChannel string
+
+const (
+	WithdrawalChannelATM   WithdrawalChannel = "atm"
+	WithdrawalChannelAgent WithdrawalChannel = "agent"
+)
+
+// WithdrawalTokenStatus defines the lifecycle state of a withdrawal code
+type WithdrawalTokenStatus string
+
+const (
+	WithdrawalTokenActive   WithdrawalTokenStatus = "active"
+	WithdrawalTokenRedeemed WithdrawalTokenStatus = "redeemed"
+	WithdrawalTokenExpired  WithdrawalTokenStatus = "expired"
+)
+
+// WithdrawalToken is a one-time code backed by funds held on the holder's wallet.
+// Only a hash of the code is stored.
+type WithdrawalToken struct {
+	ID            int64                 `json:"id"`
+	TransactionID int64                 `json:"transaction_id"`
+	UserID        int64                 `json:"user_id"`
+	MSISDN        string                `json:"msisdn"`
+	Amount        float64               `json:"amount"`
+	Channel       WithdrawalChannel     `json:"channel"`
+	Status        WithdrawalTokenStatus `json:"status"`
+	ExpiresAt     time.Time             `json:"expires_at"`
+	RedeemedAt    *time.Time            `json:"redeemed_at,omitempty"`
+	RedeemedBy    string                `json:"redeemed_by,omitempty"`
+	CreatedAt     time.Time             `json:"created_at"`
+}
+
+// ReversalStatus defines the approval state of a reversal request
+type ReversalStatus string
+
+const (
+	ReversalStatusPending  ReversalStatus = "pending"
+	ReversalStatusReviewed ReversalStatus = "reviewed" // approved by the maker, awaiting the checker
+	ReversalStatusApproved ReversalStatus = "approved"
+	ReversalStatusRejected ReversalStatus = "rejected"
+)
+
+// ReversalRequest is a user's request to undo a completed transaction
+type ReversalRequest struct {
+	ID                    int64          `json:"id"`
+	TransactionID         int64          `json:"transaction_id"`
+	TransactionReference  string         `json:"transaction_reference"`
+	RequestedBy           int64          `json:"requested_by"`
+	Reason                string         `json:"reason,omitempty"`
+	Status                ReversalStatus `json:"status"`
+	ReviewedBy            string         `json:"reviewed_by,omitempty"`
+	ApprovedBy            string         `json:"approved_by,omitempty"`
+	ReviewNote            string         `json:"review_note,omitempty"`
+	ReversalTransactionID *int64         `json:"reversal_transaction_id,omitempty"`
+	Amount                float64        `json:"amount"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+}
+
+// SMSStatus defines the delivery state of an outbound SMS
+type SMSStatus string
+
+const (
+	SMSStatusQueued    SMSStatus = "queued"
+	SMSStatusSent      SMSStatus = "sent"
+	SMSStatusDelivered SMSStatus = "delivered"
+	SMSStatusFailed    SMSStatus = "failed"
+)
+
+// SMSMessage is an outbound SMS and its delivery state
+type SMSMessage struct {
+	ID                int64      `json:"id"`
+	Reference         string     `json:"reference,omitempty"`
+	MSISDN            string     `json:"msisdn"`
+	Message           string     `json:"message"`
+	Provider          string     `json:"provider,omitempty"`
+	ProviderMessageID string     `json:"provider_message_id,omitempty"`
+	RouteID           *int64     `json:"route_id,omitempty"`
+	Operator          string     `json:"operator,omitempty"`
+	RoutingReason     string     `json:"routing_reason,omitempty"`
+	Cost              *float64   `json:"cost,omitempty"`
+	CostCurrency      string     `json:"cost_currency,omitempty"`
+	Status            SMSStatus  `json:"status"`
+	ProviderStatus    string     `json:"provider_status,omitempty"`
+	FailureReason     string     `json:"failure_reason,omitempty"`
+	Attempts          int        `json:"attempts"`
+	SentAt            *time.Time `json:"sent_at,omitempty"`
+	DeliveredAt       *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// NotificationTemplate is one version of a notification text in one language
+type NotificationTemplate struct {
+	ID        int64     `json:"id"`
+	Key       string    `json:"key"`
+	Language  string    `json:"language"`
+	Version   int       `json:"version"`
+	Body      string    `json:"body"`
+	Active    bool      `json:"active"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // AuditLog represents an audit entry for system actions
 type AuditLog struct {
 	ID         int64     `json:"id"`
@@ -0,0 +1,160 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"ussd-wrapper/models"
+)
+
+// AfricasTalkingProvider sends SMS through the Africa's Talking messaging API
+type AfricasTalkingProvider struct {
+	name     string
+	endpoint string
+	apiKey   string
+	username string
+	senderID string
+	client   *http.Client
+}
+
+// NewAfricasTalkingProvider creates a provider instance
+func NewAfricasTalkingProvider(name string, config ProviderConfig) *AfricasTalkingProvider {
+	return &AfricasTalkingProvider{
+		name:     name,
+		endpoint: config.Endpoint,
+		apiKey:   config.APIKey,
+		username: config.Username,
+		senderID: config.SenderID,
+		client:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+type atResponse struct {
+	SMSMessageData struct {
+		Message    string `json:"Message"`
+		Recipients []struct {
+			StatusCode int    `json:"statusCode"`
+			Number     string `json:"number"`
+			Status     string `json:"status"`
+			Cost       string `json:"cost"`
+			MessageID  string `json:"messageId"`
+		} `json:"Recipients"`
+	} `json:"SMSMessageData"`
+}
+
+func (a *AfricasTalkingProvider) Name() string {
+	return a.name
+}
+
+func (a *AfricasTalkingProvider) Send(ctx context.Context, msisdn, message string) (*SendResult, error) {
+	form := url.Values{}
+	form.Set("username", a.username)
+	form.Set("to", msisdn)
+	form.Set("message", message)
+	if a.senderID != "" {
+		form.Set("from", a.senderID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build sms request: %w", err)
+	}
+	req.Header.Set("apiKey", a.apiKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sms request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("sms gateway returned %d", res.StatusCode)
+	}
+
+	var body atResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return &SendResult{FailureReason: fmt.Sprintf("gateway returned %d with unreadable body", res.StatusCode)}, nil
+	}
+
+	if len(body.SMSMessageData.Recipients) == 0 {
+		return &SendResult{FailureReason: body.SMSMessageData.Message}, nil
+	}
+
+	recipient := body.SMSMessageData.Recipients[0]
+	result := &SendResult{
+		MessageID: recipient.MessageID,
+		Cost:      recipient.Cost,
+	}
+
+	// 100 Processed, 101 Sent, 102 Queued; everything else is a rejection
+	switch recipient.StatusCode {
+	case 100, 101, 102:
+		result.Accepted = true
+	case 403, 406, 409:
+		// InvalidPhoneNumber, UserInBlacklist, DoNotDisturbRejection
+		result.FailureReason = recipient.Status
+		result.FailureKind = FailureRecipient
+	default:
+		result.FailureReason = recipient.Status
+		result.FailureKind = FailureProvider
+	}
+
+	return result, nil
+}
+
+func (a *AfricasTalkingProvider) ParseDeliveryReport(form url.Values) (*DeliveryReport, error) {
+	return parseATDeliveryReport(form)
+}
+
+// parseATDeliveryReport maps an Africa's Talking delivery report callback
+func parseATDeliveryReport(form url.Values) (*DeliveryReport, error) {
+	report := &DeliveryReport{
+		MessageID:      form.Get("id"),
+		ProviderStatus: form.Get("status"),
+		FailureReason:  form.Get("failureReason"),
+	}
+	if report.MessageID == "" || report.ProviderStatus == "" {
+		return nil, fmt.Errorf("delivery report missing id or status")
+	}
+
+	switch report.ProviderStatus {
+	case "Success":
+		report.Status = models.SMSStatusDelivered
+	case "Sent", "Submitted", "Buffered":
+		report.Status = models.SMSStatusSent
+	case "Rejected", "Failed", "AbsentSubscriber", "Expired":
+		report.Status = models.SMSStatusFailed
+		report.FailureKind = atFailureKind(report.ProviderStatus, report.FailureReason)
+		if report.FailureReason == "" {
+			report.FailureReason = report.ProviderStatus
+		}
+	default:
+		return nil, fmt.Errorf("unknown delivery status %s", report.ProviderStatus)
+	}
+
+	return report, nil
+}
+
+func atFailureKind(status, reason string) FailureKind {
+	switch reason {
+	case "UserDoesNotExist", "NotNetworkSubscriber", "UserInBlackList", "UserIsInactive",
+		"UserAccountSuspended", "UserNotSubscribedToProduct":
+		return FailureRecipient
+	case "InsufficientCredit", "InvalidLinkId", "InvalidSenderId":
+		return FailureProvider
+	case "DeliveryFailure":
+		return FailureTransient
+	}
+
+	// Without a reason, a rejection is the gateway refusing the message
+	if status == "Rejected" {
+		return FailureProvider
+	}
+	return FailureTransient
+}
@@ -0,0 +1,36 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+	"ussd-wrapper/library/logger"
+)
+
+// FakeProvider accepts every message and writes it to the log, for local development
+type FakeProvider struct {
+	name string
+}
+
+// NewFakeProvider creates a provider that never contacts a gateway
+func NewFakeProvider(name string) *FakeProvider {
+	return &FakeProvider{name: name}
+}
+
+func (f *FakeProvider) Name() string {
+	return f.name
+}
+
+func (f *FakeProvider) Send(ctx context.Context, msisdn, message string) (*SendResult, error) {
+	messageID := fmt.Sprintf("FAKE%d", time.Now().UnixNano())
+	logger.WithCtx(ctx).Infof("fake sms provider %s: %s to %s: %s", f.name, messageID, msisdn, message)
+
+	return &SendResult{MessageID: messageID, Accepted: true}, nil
+}
+
+// ParseDeliveryReport reads the same form fields as Africa's Talking so the
+// failover path can be exercised locally
+func (f *FakeProvider) ParseDeliveryReport(form url.Values) (*DeliveryReport, error) {
+	return parseATDeliveryReport(form)
+}
@@ -0,0 +1,105 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	"ussd-wrapper/models"
+)
+
+// CostSummary totals the messages and cost of one provider and operator
+type CostSummary struct {
+	Provider  string  `json:"provider"`
+	Operator  string  `json:"operator"`
+	Currency  string  `json:"currency"`
+	Messages  int64   `json:"messages"`
+	Delivered int64   `json:"delivered"`
+	Cost      float64 `json:"cost"`
+}
+
+// ListMessages returns the most recent messages sent to an msisdn and/or for a reference
+func ListMessages(ctx context.Context, db *sql.DB, msisdn, reference string, limit int) ([]models.SMSMessage, error) {
+	query := "SELECT id, reference, msisdn, message, provider, provider_message_id, route_id, operator, routing_reason, cost, cost_currency, " +
+		"status, provider_status, failure_reason, attempts, sent_at, delivered_at, created_at, updated_at FROM sms_messages WHERE 1 = 1"
+	var args []interface{}
+	if msisdn != "" {
+		query += " AND msisdn = ?"
+		args = append(args, msisdn)
+	}
+	if reference != "" {
+		query += " AND reference = ?"
+		args = append(args, reference)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sms messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []models.SMSMessage{}
+	for rows.Next() {
+		var m models.SMSMessage
+		var ref, provider, providerID, operator, routingReason, currency, providerStatus, reason sql.NullString
+		var routeID sql.NullInt64
+		var cost sql.NullFloat64
+		var sentAt, deliveredAt sql.NullTime
+
+		err := rows.Scan(&m.ID, &ref, &m.MSISDN, &m.Message, &provider, &providerID, &routeID, &operator, &routingReason, &cost, &currency,
+			&m.Status, &providerStatus, &reason, &m.Attempts, &sentAt, &deliveredAt, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sms message: %w", err)
+		}
+
+		m.Reference = ref.String
+		m.Provider = provider.String
+		m.ProviderMessageID = providerID.String
+		m.Operator = operator.String
+		m.RoutingReason = routingReason.String
+		m.CostCurrency = currency.String
+		if routeID.Valid {
+			m.RouteID = &routeID.Int64
+		}
+		if cost.Valid {
+			m.Cost = &cost.Float64
+		}
+		m.ProviderStatus = providerStatus.String
+		m.FailureReason = reason.String
+		if sentAt.Valid {
+			m.SentAt = &sentAt.Time
+		}
+		if deliveredAt.Valid {
+			m.DeliveredAt = &deliveredAt.Time
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// SummarizeCosts totals the recorded cost of messages created in [from, to) by provider and operator
+func SummarizeCosts(ctx context.Context, db *sql.DB, from, to time.Time) ([]CostSummary, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT COALESCE(provider, ''), COALESCE(operator, ''), COALESCE(cost_currency, ''), COUNT(*), "+
+			"SUM(status = ?), COALESCE(SUM(cost), 0) FROM sms_messages WHERE created_at >= ? AND created_at < ? "+
+			"GROUP BY 1, 2, 3 ORDER BY 1, 2",
+		models.SMSStatusDelivered, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize sms costs: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []CostSummary{}
+	for rows.Next() {
+		var c CostSummary
+		if err := rows.Scan(&c.Provider, &c.Operator, &c.Currency, &c.Messages, &c.Delivered, &c.Cost); err != nil {
+			return nil, fmt.Errorf("failed to scan sms cost summary: %w", err)
+		}
+		summaries = append(summaries, c)
+	}
+
+	return summaries, rows.Err()
+}
@@ -0,0 +1,121 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/outbox"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Notification asks for a templated message to be sent to a subscriber
+type Notification struct {
+	Template  string                 `json:"template"`
+	MSISDN    string                 `json:"msisdn"`
+	Reference string                 `json:"reference,omitempty"`
+	Language  string                 `json:"language,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+
+	// Priority of the SMS; when zero the template's priority (see TemplatePriority) is used
+	Priority connections.Priority `json:"priority,omitempty"`
+
+	// Secret marks data holding a one-time secret, such as a withdrawal code. The
+	// notification and its SMS text are purged once they have been sent.
+	Secret bool `json:"secret,omitempty"`
+}
+
+// Notifier queues templated notifications. db is the transaction of the business
+// change, so the notification is sent if and only if that change commits.
+type Notifier interface {
+	Notify(ctx context.Context, db library.Execer, n Notification) error
+}
+
+// OutboxNotifier writes notifications to the outbox for the TemplateWorker to render and send
+type OutboxNotifier struct {
+	tracer trace.Tracer
+	queue  string
+}
+
+// NewOutboxNotifier creates a notifier for the notifications queue
+func NewOutboxNotifier(config Config, tracer trace.Tracer) *OutboxNotifier {
+	return &OutboxNotifier{
+		tracer: tracer,
+		queue:  library.QueueName(config.Queues, constants.NotificationsChannel),
+	}
+}
+
+// Notify stores the notification in the outbox; events of one reference are published in order
+func (o *OutboxNotifier) Notify(ctx context.Context, db library.Execer, n Notification) error {
+	ctx, span := o.tracer.Start(ctx, "Notify",
+		trace.WithAttributes(attribute.String("template", n.Template), attribute.String("reference", n.Reference)))
+	defer span.End()
+
+	if n.Priority == connections.PriorityBulk {
+		n.Priority = TemplatePriority(n.Template)
+	}
+
+	return outbox.Enqueue(ctx, db, outbox.Event{
+		AggregateType: "transaction",
+		AggregateID:   n.Reference,
+		EventType:     "notification." + n.Template,
+		Queue:         o.queue,
+		Payload:       n,
+		Priority:      n.Priority,
+		Secret:        n.Secret,
+	})
+}
+
+// TemplateWorker renders queued notifications in the recipient's language and sends them as SMS
+type TemplateWorker struct {
+	db        *sql.DB
+	tracer    trace.Tracer
+	templates *Templates
+	sender    Sender
+}
+
+// NewTemplateWorker creates the consumer of the notifications queue
+func NewTemplateWorker(db *sql.DB, tracer trace.Tracer, templates *Templates, sender Sender) *TemplateWorker {
+	return &TemplateWorker{
+		db:        db,
+		tracer:    tracer,
+		templates: templates,
+		sender:    sender,
+	}
+}
+
+// Handle renders and sends one notification. An error is returned only when it should be retried.
+func (w *TemplateWorker) Handle(ctx context.Context, n Notification) error {
+	ctx, span := w.tracer.Start(ctx, "HandleNotification",
+		trace.WithAttributes(attribute.String("template", n.Template), attribute.String("reference", n.Reference)))
+	defer span.End()
+
+	language := n.Language
+	if language == "" {
+		err := w.db.QueryRowContext(ctx, "SELECT language FROM users WHERE phone_number = ?", n.MSISDN).Scan(&language)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to load language of %s: %w", n.MSISDN, err)
+		}
+	}
+
+	message, err := w.templates.Render(ctx, n.Template, language, n.Data)
+	if errors.Is(err, ErrTemplateNotFound) || errors.Is(err, ErrInvalidTemplate) || errors.Is(err, ErrMissingPlaceholder) {
+		// Retrying cannot fix the template or the data
+		logger.WithCtx(ctx).Errorf("discarding %s notification for %s: %v", n.Template, n.Reference, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if n.Secret {
+		return w.sender.SendSecretSMS(ctx, n.MSISDN, message, n.Reference, n.Priority)
+	}
+	return w.sender.SendSMS(ctx, n.MSISDN, message, n.Reference, n.Priority)
+}
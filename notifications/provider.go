@@ -0,0 +1,129 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"ussd-wrapper/models"
+)
+
+// FailureKind says what a failed message needs before it can be delivered
+type FailureKind string
+
+const (
+	// FailureTransient may succeed if the same provider tries again
+	FailureTransient FailureKind = "transient"
+	// FailureProvider is a permanent failure of the provider itself, e.g. no credit
+	FailureProvider FailureKind = "provider"
+	// FailureRecipient will fail on every provider, e.g. an invalid number
+	FailureRecipient FailureKind = "recipient"
+)
+
+// SendResult is the provider's answer to a send request
+type SendResult struct {
+	MessageID     string
+	Accepted      bool
+	FailureReason string
+	FailureKind   FailureKind
+	Cost          string
+}
+
+// DeliveryReport is a provider's final or intermediate status for a sent message
+type DeliveryReport struct {
+	MessageID      string
+	Status         models.SMSStatus
+	ProviderStatus string
+	FailureReason  string
+	FailureKind    FailureKind
+}
+
+// Provider is implemented by every SMS gateway adapter.
+// Send returns an error only for transport failures worth retrying; a message
+// the gateway rejects is reported through SendResult.Accepted.
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, msisdn, message string) (*SendResult, error)
+	ParseDeliveryReport(form url.Values) (*DeliveryReport, error)
+}
+
+// Config holds the settings of the SMS providers, router, worker and templates
+type Config struct {
+	Queues            string   `mapstructure:"queues"` // suffix of the queue names publishers address
+	Provider          string   `mapstructure:"sms_provider"`
+	SecondaryProvider string   `mapstructure:"sms_secondary_provider"`
+	Providers         []string `mapstructure:"sms_providers"` // extra provider instances for sms_routes
+
+	MaxAttempts      int `mapstructure:"sms_max_attempts"`
+	ThrottleLimit    int `mapstructure:"sms_throttle_limit"`
+	ThrottleWindow   int `mapstructure:"sms_throttle_window"` // seconds
+	RouteRefresh     int `mapstructure:"sms_route_refresh"`   // seconds
+	FailureThreshold int `mapstructure:"sms_provider_failure_threshold"`
+	Cooldown         int `mapstructure:"sms_provider_cooldown"` // seconds
+
+	DefaultLanguage  string `mapstructure:"default_language"`
+	TemplateCacheTTL int    `mapstructure:"template_cache_ttl"` // seconds
+
+	// Settings of every provider instance by name, read from sms_<name>_* keys
+	Instances map[string]ProviderConfig `mapstructure:"-"`
+}
+
+// ProviderConfig configures one SMS provider instance
+type ProviderConfig struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+	APIKey   string `json:"api_key"`
+	Username string `json:"username"`
+	SenderID string `json:"sender_id"`
+}
+
+// ProviderTypes are the supported values of sms_<name>_type
+var ProviderTypes = []string{"africastalking", "fake"}
+
+// ProviderNames returns the provider instances named in sms_provider,
+// sms_secondary_provider and sms_providers, primary first
+func (c Config) ProviderNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range append([]string{c.Provider, c.SecondaryProvider}, c.Providers...) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// NewProviders builds every SMS provider instance of the config, so one gateway
+// can be configured several times with different accounts
+func NewProviders(config Config) (map[string]Provider, error) {
+	providers := make(map[string]Provider)
+	for _, name := range config.ProviderNames() {
+		provider, err := NewProviderNamed(name, config.Instances[name])
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+
+	return providers, nil
+}
+
+// NewProviderNamed builds an SMS provider instance; its type defaults to its name
+func NewProviderNamed(name string, config ProviderConfig) (Provider, error) {
+	providerType := strings.ToLower(config.Type)
+	if providerType == "" {
+		providerType = name
+	}
+
+	switch providerType {
+	case "africastalking":
+		return NewAfricasTalkingProvider(name, config), nil
+	case "fake":
+		return NewFakeProvider(name), nil
+	default:
+		return nil, fmt.Errorf("unsupported sms provider type %s for %s", providerType, name)
+	}
+}
@@ -0,0 +1,349 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"ussd-wrapper/library/logger"
+)
+
+var ErrNoRoute = errors.New("no sms provider available")
+
+// RouteDecision records which provider a message goes through and why
+type RouteDecision struct {
+	Provider Provider
+	RouteID  *int64
+	Operator string
+	Reason   string
+	Cost     *float64
+	Currency string
+}
+
+type smsRoute struct {
+	id       int64
+	prefix   string
+	operator string
+	provider string
+	priority int
+}
+
+type operatorPrefix struct {
+	prefix   string
+	operator string
+}
+
+type providerCost struct {
+	provider string
+	prefix   string
+	operator string
+	cost     float64
+	currency string
+}
+
+type providerHealth struct {
+	failures  int
+	downUntil time.Time
+}
+
+// Router picks the SMS provider for a recipient from the sms_routes table.
+// Rules match on MSISDN prefix and/or operator and are tried in priority order;
+// sms_provider and sms_secondary_provider are the fallbacks when no rule matches.
+// A provider that fails sms_provider_failure_threshold times in a row is skipped
+// for sms_provider_cooldown seconds.
+type Router struct {
+	db        *sql.DB
+	providers map[string]Provider
+	defaults  []string
+
+	refreshEvery     time.Duration
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu       sync.RWMutex
+	routes   []smsRoute
+	prefixes []operatorPrefix
+	costs    []providerCost
+	loadedAt time.Time
+
+	healthMu sync.Mutex
+	health   map[string]*providerHealth
+}
+
+// NewRouter builds the configured providers and a router over them
+func NewRouter(config Config, db *sql.DB) (*Router, error) {
+	providers, err := NewProviders(config)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := []string{strings.ToLower(config.Provider)}
+	if secondary := strings.ToLower(config.SecondaryProvider); secondary != "" {
+		defaults = append(defaults, secondary)
+	}
+
+	return &Router{
+		db:               db,
+		providers:        providers,
+		defaults:         defaults,
+		refreshEvery:     time.Duration(config.RouteRefresh) * time.Second,
+		failureThreshold: config.FailureThreshold,
+		cooldown:         time.Duration(config.Cooldown) * time.Second,
+		health:           make(map[string]*providerHealth),
+	}, nil
+}
+
+// Provider returns a configured provider by name
+func (r *Router) Provider(name string) (Provider, bool) {
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// Default returns the primary provider
+func (r *Router) Default() Provider {
+	return r.providers[r.defaults[0]]
+}
+
+// Route picks the provider for msisdn, skipping the excluded provider and unhealthy ones.
+// When every candidate is unhealthy the best ranked one is used anyway.
+func (r *Router) Route(ctx context.Context, msisdn, exclude string) (*RouteDecision, error) {
+	if err := r.refresh(ctx); err != nil {
+		// Keep routing on the last good rules
+		logger.WithCtx(ctx).Errorf("failed to refresh sms routes: %v", err)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	number := strings.TrimPrefix(msisdn, "+")
+	operator := r.operatorFor(number)
+
+	type candidate struct {
+		provider string
+		routeID  *int64
+		reason   string
+	}
+	var candidates []candidate
+	for _, route := range r.routes {
+		if route.prefix != "" && !strings.HasPrefix(number, route.prefix) {
+			continue
+		}
+		if route.operator != "" && route.operator != operator {
+			continue
+		}
+		id := route.id
+		candidates = append(candidates, candidate{route.provider, &id, fmt.Sprintf("route %d", route.id)})
+	}
+	for _, name := range r.defaults {
+		candidates = append(candidates, candidate{name, nil, "default"})
+	}
+
+	var chosen *candidate
+	var skipped []string
+	for i := range candidates {
+		c := &candidates[i]
+		if c.provider == exclude || r.providers[c.provider] == nil {
+			continue
+		}
+		if !r.Healthy(c.provider) {
+			skipped = append(skipped, c.provider)
+			if chosen == nil {
+				chosen = c
+			}
+			continue
+		}
+		chosen = c
+		break
+	}
+	if chosen == nil {
+		return nil, ErrNoRoute
+	}
+
+	reason := chosen.reason
+	if len(skipped) > 0 {
+		reason += fmt.Sprintf("; unhealthy: %s", strings.Join(skipped, ","))
+	}
+
+	decision := &RouteDecision{
+		Provider: r.providers[chosen.provider],
+		RouteID:  chosen.routeID,
+		Operator: operator,
+		Reason:   reason,
+	}
+	r.applyCost(decision, number)
+
+	return decision, nil
+}
+
+// Retry keeps a message on the provider it was last sent through
+func (r *Router) Retry(ctx context.Context, msisdn, providerName string) (*RouteDecision, error) {
+	provider, ok := r.providers[providerName]
+	if !ok {
+		return r.Route(ctx, msisdn, "")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	number := strings.TrimPrefix(msisdn, "+")
+	decision := &RouteDecision{
+		Provider: provider,
+		Operator: r.operatorFor(number),
+		Reason:   "retry",
+	}
+	r.applyCost(decision, number)
+
+	return decision, nil
+}
+
+// Healthy reports whether a provider is outside its failure cooldown
+func (r *Router) Healthy(name string) bool {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+
+	h := r.health[name]
+	return h == nil || time.Now().After(h.downUntil)
+}
+
+// ReportSuccess resets the failure count of a provider
+func (r *Router) ReportSuccess(name string) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+
+	if h := r.health[name]; h != nil {
+		h.failures = 0
+	}
+}
+
+// ReportFailure counts a provider error and takes the provider out of rotation
+// once it reaches the failure threshold
+func (r *Router) ReportFailure(ctx context.Context, name string) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+
+	h := r.health[name]
+	if h == nil {
+		h = &providerHealth{}
+		r.health[name] = h
+	}
+
+	h.failures++
+	if h.failures >= r.failureThreshold {
+		h.failures = 0
+		h.downUntil = time.Now().Add(r.cooldown)
+		logger.WithCtx(ctx).Warnf("sms provider %s marked unhealthy for %s", name, r.cooldown)
+	}
+}
+
+// refresh reloads the routing tables once they are older than sms_route_refresh
+func (r *Router) refresh(ctx context.Context) error {
+	r.mu.RLock()
+	fresh := !r.loadedAt.IsZero() && time.Since(r.loadedAt) < r.refreshEvery
+	r.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	var routes []smsRoute
+	rows, err := r.db.QueryContext(ctx, "SELECT id, prefix, operator, LOWER(provider), priority FROM sms_routes WHERE active = TRUE")
+	if err != nil {
+		return fmt.Errorf("failed to load sms routes: %w", err)
+	}
+	for rows.Next() {
+		var route smsRoute
+		if err := rows.Scan(&route.id, &route.prefix, &route.operator, &route.provider, &route.priority); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan sms route: %w", err)
+		}
+		routes = append(routes, route)
+	}
+	rows.Close()
+
+	// Lowest priority value first, then the most specific rule
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].priority != routes[j].priority {
+			return routes[i].priority < routes[j].priority
+		}
+		if len(routes[i].prefix) != len(routes[j].prefix) {
+			return len(routes[i].prefix) > len(routes[j].prefix)
+		}
+		return routes[i].operator != "" && routes[j].operator == ""
+	})
+
+	var prefixes []operatorPrefix
+	rows, err = r.db.QueryContext(ctx, "SELECT prefix, operator FROM sms_operator_prefixes")
+	if err != nil {
+		return fmt.Errorf("failed to load operator prefixes: %w", err)
+	}
+	for rows.Next() {
+		var p operatorPrefix
+		if err := rows.Scan(&p.prefix, &p.operator); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan operator prefix: %w", err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	rows.Close()
+
+	var costs []providerCost
+	rows, err = r.db.QueryContext(ctx, "SELECT LOWER(provider), prefix, operator, cost, currency FROM sms_provider_costs")
+	if err != nil {
+		return fmt.Errorf("failed to load sms costs: %w", err)
+	}
+	for rows.Next() {
+		var c providerCost
+		if err := rows.Scan(&c.provider, &c.prefix, &c.operator, &c.cost, &c.currency); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan sms cost: %w", err)
+		}
+		costs = append(costs, c)
+	}
+	rows.Close()
+
+	r.mu.Lock()
+	r.routes, r.prefixes, r.costs, r.loadedAt = routes, prefixes, costs, time.Now()
+	r.mu.Unlock()
+
+	return nil
+}
+
+// operatorFor returns the operator of the longest matching prefix. Callers hold r.mu.
+func (r *Router) operatorFor(number string) string {
+	operator, longest := "", 0
+	for _, p := range r.prefixes {
+		if len(p.prefix) > longest && strings.HasPrefix(number, p.prefix) {
+			operator, longest = p.operator, len(p.prefix)
+		}
+	}
+	return operator
+}
+
+// applyCost sets the most specific cost entry of the chosen provider. Callers hold r.mu.
+func (r *Router) applyCost(decision *RouteDecision, number string) {
+	best := -1
+	for _, c := range r.costs {
+		if c.provider != decision.Provider.Name() {
+			continue
+		}
+		if c.prefix != "" && !strings.HasPrefix(number, c.prefix) {
+			continue
+		}
+		if c.operator != "" && c.operator != decision.Operator {
+			continue
+		}
+
+		score := len(c.prefix) * 2
+		if c.operator != "" {
+			score++
+		}
+		if score > best {
+			best = score
+			cost := c.cost
+			decision.Cost, decision.Currency = &cost, c.currency
+		}
+	}
+}
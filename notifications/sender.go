@@ -0,0 +1,167 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/inbox"
+	"ussd-wrapper/library"
+	"ussd-wrapper/models"
+	"ussd-wrapper/outbox"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sender delivers a text message to a subscriber
+type Sender interface {
+	SendSMS(ctx context.Context, msisdn, message, reference string, priority connections.Priority) error
+
+	// SendSecretSMS sends a message holding a one-time secret, whose text is
+	// purged once the gateway accepts it or it fails for good
+	SendSecretSMS(ctx context.Context, msisdn, message, reference string, priority connections.Priority) error
+}
+
+// SMSJob is the message published to the outbound SMS queue
+type SMSJob struct {
+	ID        int64  `json:"id"`
+	Reference string `json:"reference,omitempty"`
+	MSISDN    string `json:"msisdn"`
+	Message   string `json:"message"`
+}
+
+// Publisher records outbound messages and hands them to the SMS worker through the outbox
+type Publisher struct {
+	db     *sql.DB
+	tracer trace.Tracer
+	queue  string
+}
+
+// NewPublisher creates a publisher for the outbound SMS queue
+func NewPublisher(config Config, db *sql.DB, tracer trace.Tracer) *Publisher {
+	return &Publisher{
+		db:     db,
+		tracer: tracer,
+		queue:  library.QueueName(config.Queues, constants.SMSOutboundChannel),
+	}
+}
+
+// SendSMS stores the message as queued together with the outbox event for the worker.
+// Messages of a higher priority overtake a backlog of lower ones on the SMS queue.
+// Sent while handling a queued message, it returns inbox.ErrDuplicate if that message was already sent.
+func (p *Publisher) SendSMS(ctx context.Context, msisdn, message, reference string, priority connections.Priority) error {
+	return p.send(ctx, msisdn, message, reference, priority, false)
+}
+
+// SendSecretSMS stores and queues a message like SendSMS, marked to be purged once sent
+func (p *Publisher) SendSecretSMS(ctx context.Context, msisdn, message, reference string, priority connections.Priority) error {
+	return p.send(ctx, msisdn, message, reference, priority, true)
+}
+
+func (p *Publisher) send(ctx context.Context, msisdn, message, reference string, priority connections.Priority, secret bool) error {
+	ctx, span := p.tracer.Start(ctx, "SendSMS",
+		trace.WithAttributes(attribute.String("reference", reference), attribute.String("priority", priority.String())))
+	defer span.End()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO sms_messages (reference, msisdn, message, priority, secret, status) VALUES (?, ?, ?, ?, ?, ?)",
+		reference, msisdn, message, priority, secret, models.SMSStatusQueued)
+	if err != nil {
+		return fmt.Errorf("failed to store sms to %s: %w", msisdn, err)
+	}
+	id, _ := res.LastInsertId()
+
+	job := SMSJob{
+		ID:        id,
+		Reference: reference,
+		MSISDN:    msisdn,
+		Message:   message,
+	}
+	if err := p.enqueue(ctx, tx, job, priority, secret); err != nil {
+		return err
+	}
+	// A redelivered notification finds its mark and rolls back instead of sending twice
+	if err := inbox.Record(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit sms %d: %w", id, err)
+	}
+	return nil
+}
+
+func (p *Publisher) enqueue(ctx context.Context, tx *sql.Tx, job SMSJob, priority connections.Priority, secret bool) error {
+	return outbox.Enqueue(ctx, tx, outbox.Event{
+		AggregateType: "sms_message",
+		AggregateID:   strconv.FormatInt(job.ID, 10),
+		EventType:     "sms.send",
+		Queue:         p.queue,
+		Payload:       job,
+		Priority:      priority,
+		Secret:        secret,
+	})
+}
+
+// Resend queues a stored message again through the provider of the routing decision
+func (p *Publisher) Resend(ctx context.Context, id int64, decision *RouteDecision) error {
+	ctx, span := p.tracer.Start(ctx, "ResendSMS",
+		trace.WithAttributes(attribute.Int64("sms_id", id), attribute.String("provider", decision.Provider.Name())))
+	defer span.End()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var job SMSJob
+	var reference sql.NullString
+	var priority connections.Priority
+	var secret bool
+	err = tx.QueryRowContext(ctx, "SELECT id, reference, msisdn, message, priority, secret FROM sms_messages WHERE id = ? FOR UPDATE", id).
+		Scan(&job.ID, &reference, &job.MSISDN, &job.Message, &priority, &secret)
+	if err != nil {
+		return fmt.Errorf("failed to load sms %d: %w", id, err)
+	}
+	job.Reference = reference.String
+	if secret && job.Message == PurgedMessage {
+		return ErrMessagePurged
+	}
+
+	if err := recordRoute(ctx, tx, id, models.SMSStatusQueued, decision); err != nil {
+		return fmt.Errorf("failed to requeue sms %d: %w", id, err)
+	}
+	if err := p.enqueue(ctx, tx, job, priority, secret); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit resend of sms %d: %w", id, err)
+	}
+	return nil
+}
+
+// purgeSecret replaces the text of a secret message once it can no longer be resent
+func purgeSecret(ctx context.Context, db library.Execer, id int64) error {
+	_, err := db.ExecContext(ctx, "UPDATE sms_messages SET message = ? WHERE id = ? AND secret", PurgedMessage, id)
+	return err
+}
+
+// recordRoute pins a message to the provider of a routing decision and clears the previous attempt
+func recordRoute(ctx context.Context, db library.Execer, id int64, status models.SMSStatus, decision *RouteDecision) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE sms_messages SET status = ?, provider = ?, provider_message_id = NULL, provider_status = NULL, sent_at = NULL, "+
+			"route_id = ?, operator = ?, routing_reason = ?, cost = ?, cost_currency = ? WHERE id = ?",
+		status, decision.Provider.Name(), decision.RouteID, decision.Operator, decision.Reason, decision.Cost, decision.Currency, id)
+	return err
+}
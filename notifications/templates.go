@@ -0,0 +1,485 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/library"
+	"ussd-wrapper/models"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Template keys for transaction receipts
+const (
+	TemplateDepositCompleted      = "deposit_completed"
+	TemplateDepositFailed         = "deposit_failed"
+	TemplateWithdrawalCodeIssued  = "withdrawal_code_issued"
+	TemplateWithdrawalCompleted   = "withdrawal_completed"
+	TemplateWithdrawalCodeExpired = "withdrawal_code_expired"
+	TemplatePayoutCompleted       = "payout_completed"
+	TemplatePayoutFailed          = "payout_failed"
+	TemplateTransferSent          = "transfer_sent"
+	TemplateTransferReceived      = "transfer_received"
+	TemplateReversalRejected      = "reversal_rejected"
+	TemplateReversalRefunded      = "reversal_refunded"
+	TemplateReversalDebited       = "reversal_debited"
+)
+
+// templatePriorities ranks receipts: the withdrawal code is an OTP the customer is
+// waiting for at the agent, completed money movements come before failure notices
+var templatePriorities = map[string]connections.Priority{
+	TemplateWithdrawalCodeIssued: connections.PriorityCritical,
+	TemplateDepositCompleted:     connections.PriorityHigh,
+	TemplateWithdrawalCompleted:  connections.PriorityHigh,
+	TemplatePayoutCompleted:      connections.PriorityHigh,
+	TemplateTransferSent:         connections.PriorityHigh,
+	TemplateTransferReceived:     connections.PriorityHigh,
+	TemplateReversalRefunded:     connections.PriorityHigh,
+	TemplateReversalDebited:      connections.PriorityHigh,
+}
+
+// TemplatePriority returns the SMS priority of a template, PriorityNormal unless ranked otherwise
+func TemplatePriority(template string) connections.Priority {
+	if p, ok := templatePriorities[template]; ok {
+		return p
+	}
+	return connections.PriorityNormal
+}
+
+var (
+	ErrTemplateNotFound   = errors.New("template not found")
+	ErrInvalidTemplate    = errors.New("invalid template")
+	ErrMissingPlaceholder = errors.New("missing placeholder value")
+)
+
+// PlaceholderType decides how a placeholder value is formatted
+type PlaceholderType string
+
+const (
+	PlaceholderAmount    PlaceholderType = "amount"
+	PlaceholderCurrency  PlaceholderType = "currency"
+	PlaceholderReference PlaceholderType = "reference"
+	PlaceholderMSISDN    PlaceholderType = "msisdn"
+	PlaceholderText      PlaceholderType = "text"
+	PlaceholderTime      PlaceholderType = "time"
+)
+
+// Placeholders lists every placeholder a template may use
+var Placeholders = map[string]PlaceholderType{
+	"amount":       PlaceholderAmount,
+	"currency":     PlaceholderCurrency,
+	"reference":    PlaceholderReference,
+	"msisdn":       PlaceholderMSISDN,
+	"counterparty": PlaceholderMSISDN,
+	"name":         PlaceholderText,
+	"code":         PlaceholderText,
+	"channel":      PlaceholderText,
+	"reason":       PlaceholderText,
+	"expires_at":   PlaceholderTime,
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z_]+)\s*\}\}`)
+
+// ParseTemplate returns the placeholders used by a template body and rejects unknown ones
+func ParseTemplate(body string) ([]string, error) {
+	if strings.TrimSpace(body) == "" {
+		return nil, fmt.Errorf("%w: body is empty", ErrInvalidTemplate)
+	}
+
+	var names []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(body, -1) {
+		name := match[1]
+		if _, ok := Placeholders[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown placeholder %s", ErrInvalidTemplate, name)
+		}
+		names = append(names, name)
+	}
+
+	if strings.Contains(placeholderPattern.ReplaceAllString(body, ""), "{{") {
+		return nil, fmt.Errorf("%w: malformed placeholder", ErrInvalidTemplate)
+	}
+
+	return names, nil
+}
+
+// RenderBody fills the placeholders of a template body with formatted values
+func RenderBody(body string, data map[string]interface{}) (string, error) {
+	if _, err := ParseTemplate(body); err != nil {
+		return "", err
+	}
+
+	var renderErr error
+	out := placeholderPattern.ReplaceAllStringFunc(body, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		value, ok := data[name]
+		if !ok || value == nil {
+			if renderErr == nil {
+				renderErr = fmt.Errorf("%w: %s", ErrMissingPlaceholder, name)
+			}
+			return match
+		}
+
+		formatted, err := formatPlaceholder(Placeholders[name], value)
+		if err != nil && renderErr == nil {
+			renderErr = fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+		}
+		return formatted
+	})
+
+	return out, renderErr
+}
+
+// SampleData returns an example value for every placeholder, for previews
+func (t *Templates) SampleData() map[string]interface{} {
+	return map[string]interface{}{
+		"amount":       1500.5,
+		"currency":     t.currency,
+		"reference":    "TR0123456789ABCDEF",
+		"msisdn":       "254712345678",
+		"counterparty": "254798765432",
+		"name":         "Jane Doe",
+		"code":         "12345678",
+		"channel":      "agent",
+		"reason":       "Insufficient balance",
+		"expires_at":   time.Now().Add(30 * time.Minute),
+	}
+}
+
+func formatPlaceholder(kind PlaceholderType, value interface{}) (string, error) {
+	switch kind {
+	case PlaceholderAmount:
+		amount, err := toFloat(value)
+		if err != nil {
+			return "", err
+		}
+		return formatAmount(amount), nil
+	case PlaceholderCurrency:
+		return strings.ToUpper(fmt.Sprint(value)), nil
+	case PlaceholderMSISDN:
+		return MaskMSISDN(fmt.Sprint(value)), nil
+	case PlaceholderTime:
+		t, err := toTime(value)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("02 Jan 2006 15:04"), nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// MaskMSISDN hides the middle digits of a phone number, e.g. 2547****5678
+func MaskMSISDN(msisdn string) string {
+	n := len(msisdn)
+	if n <= 4 {
+		return msisdn
+	}
+	keepStart, keepEnd := 4, 4
+	if n < keepStart+keepEnd+2 {
+		keepStart, keepEnd = 0, 2
+	}
+	return msisdn[:keepStart] + strings.Repeat("*", n-keepStart-keepEnd) + msisdn[n-keepEnd:]
+}
+
+// formatAmount renders an amount with two decimals and thousands separators
+func formatAmount(amount float64) string {
+	s := strconv.FormatFloat(amount, 'f', 2, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+
+	whole, fraction := s[:len(s)-3], s[len(s)-3:]
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return sign + b.String() + fraction
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("%v is not a number", value)
+	}
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		return time.Parse(time.RFC3339, v)
+	default:
+		return time.Time{}, fmt.Errorf("%v is not a time", value)
+	}
+}
+
+type cachedTemplate struct {
+	template *models.NotificationTemplate
+	loadedAt time.Time
+}
+
+// Templates is the versioned template registry stored in notification_templates.
+// Active versions are cached for template_cache_ttl seconds.
+type Templates struct {
+	db              *sql.DB
+	tracer          trace.Tracer
+	defaultLanguage string
+	cacheTTL        time.Duration
+	currency        string
+
+	mu    sync.RWMutex
+	cache map[string]cachedTemplate
+}
+
+// NewTemplates creates the template registry
+func NewTemplates(config Config, db *sql.DB, tracer trace.Tracer, currency string) *Templates {
+	return &Templates{
+		db:              db,
+		tracer:          tracer,
+		defaultLanguage: config.DefaultLanguage,
+		cacheTTL:        time.Duration(config.TemplateCacheTTL) * time.Second,
+		currency:        currency,
+		cache:           make(map[string]cachedTemplate),
+	}
+}
+
+// Active returns the active version of a template, falling back to the default language
+func (t *Templates) Active(ctx context.Context, key, language string) (*models.NotificationTemplate, error) {
+	if language == "" {
+		language = t.defaultLanguage
+	}
+
+	tmpl, err := t.active(ctx, key, language)
+	if errors.Is(err, ErrTemplateNotFound) && language != t.defaultLanguage {
+		return t.active(ctx, key, t.defaultLanguage)
+	}
+	return tmpl, err
+}
+
+func (t *Templates) active(ctx context.Context, key, language string) (*models.NotificationTemplate, error) {
+	cacheKey := key + ":" + language
+
+	t.mu.RLock()
+	cached, ok := t.cache[cacheKey]
+	t.mu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < t.cacheTTL {
+		return cached.template, nil
+	}
+
+	tmpl, err := scanTemplate(t.db.QueryRowContext(ctx, templateSelect+" WHERE template_key = ? AND language = ? AND active = TRUE", key, language))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template %s/%s: %w", key, language, err)
+	}
+
+	t.mu.Lock()
+	t.cache[cacheKey] = cachedTemplate{template: tmpl, loadedAt: time.Now()}
+	t.mu.Unlock()
+
+	return tmpl, nil
+}
+
+// Render renders the active version of a template in the given language
+func (t *Templates) Render(ctx context.Context, key, language string, data map[string]interface{}) (string, error) {
+	ctx, span := t.tracer.Start(ctx, "RenderTemplate",
+		trace.WithAttributes(attribute.String("template", key), attribute.String("language", language)))
+	defer span.End()
+
+	tmpl, err := t.Active(ctx, key, language)
+	if err != nil {
+		return "", err
+	}
+	return RenderBody(tmpl.Body, data)
+}
+
+// List returns every version of the templates, optionally for one key, newest first
+func (t *Templates) List(ctx context.Context, key string) ([]models.NotificationTemplate, error) {
+	query := templateSelect
+	var args []interface{}
+	if key != "" {
+		query += " WHERE template_key = ?"
+		args = append(args, key)
+	}
+	query += " ORDER BY template_key, language, version DESC"
+
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []models.NotificationTemplate{}
+	for rows.Next() {
+		tmpl, err := scanTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		templates = append(templates, *tmpl)
+	}
+
+	return templates, rows.Err()
+}
+
+// Create stores a new version of a template and makes it the active one
+func (t *Templates) Create(ctx context.Context, key, language, body, admin string) (*models.NotificationTemplate, error) {
+	ctx, span := t.tracer.Start(ctx, "CreateTemplate",
+		trace.WithAttributes(attribute.String("template", key), attribute.String("language", language)))
+	defer span.End()
+
+	if key == "" || language == "" {
+		return nil, fmt.Errorf("%w: key and language are required", ErrInvalidTemplate)
+	}
+	if _, err := ParseTemplate(body); err != nil {
+		return nil, err
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(version), 0) + 1 FROM notification_templates WHERE template_key = ? AND language = ? FOR UPDATE",
+		key, language).Scan(&version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate template version: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE notification_templates SET active = FALSE WHERE template_key = ? AND language = ?", key, language)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate template %s/%s: %w", key, language, err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO notification_templates (template_key, language, version, body, active, created_by) VALUES (?, ?, ?, ?, TRUE, ?)",
+		key, language, version, body, admin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create template %s/%s: %w", key, language, err)
+	}
+	id, _ := res.LastInsertId()
+
+	err = library.RecordAudit(ctx, tx, models.AuditLog{
+		Action:     "template_created",
+		EntityType: "notification_template",
+		EntityID:   strconv.FormatInt(id, 10),
+		NewValue:   models.JSONMap{"key": key, "language": language, "version": version, "body": body, "admin": admin},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit template %s/%s: %w", key, language, err)
+	}
+	t.invalidate(key, language)
+
+	return t.Get(ctx, id)
+}
+
+// Activate makes an existing version the active one, e.g. to roll back an edit
+func (t *Templates) Activate(ctx context.Context, id int64, admin string) (*models.NotificationTemplate, error) {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tmpl, err := scanTemplate(tx.QueryRowContext(ctx, templateSelect+" WHERE id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template %d: %w", id, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE notification_templates SET active = (id = ?) WHERE template_key = ? AND language = ?",
+		id, tmpl.Key, tmpl.Language)
+	if err != nil {
+		return nil, fmt.Errorf("failed to activate template %d: %w", id, err)
+	}
+
+	err = library.RecordAudit(ctx, tx, models.AuditLog{
+		Action:     "template_activated",
+		EntityType: "notification_template",
+		EntityID:   strconv.FormatInt(id, 10),
+		NewValue:   models.JSONMap{"key": tmpl.Key, "language": tmpl.Language, "version": tmpl.Version, "admin": admin},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit template %d: %w", id, err)
+	}
+	t.invalidate(tmpl.Key, tmpl.Language)
+
+	tmpl.Active = true
+	return tmpl, nil
+}
+
+// Get returns one template version by id
+func (t *Templates) Get(ctx context.Context, id int64) (*models.NotificationTemplate, error) {
+	tmpl, err := scanTemplate(t.db.QueryRowContext(ctx, templateSelect+" WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template %d: %w", id, err)
+	}
+	return tmpl, nil
+}
+
+func (t *Templates) invalidate(key, language string) {
+	t.mu.Lock()
+	delete(t.cache, key+":"+language)
+	t.mu.Unlock()
+}
+
+const templateSelect = "SELECT id, template_key, language, version, body, active, created_by, created_at FROM notification_templates"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTemplate(row rowScanner) (*models.NotificationTemplate, error) {
+	var tmpl models.NotificationTemplate
+	var createdBy sql.NullString
+
+	err := row.Scan(&tmpl.ID, &tmpl.Key, &tmpl.Language, &tmpl.Version, &tmpl.Body, &tmpl.Active, &createdBy, &tmpl.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.CreatedBy = createdBy.String
+	return &tmpl, nil
+}
@@ -0,0 +1,276 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrUnknownProvider       = errors.New("unknown sms provider")
+	ErrInvalidDeliveryReport = errors.New("invalid delivery report")
+	ErrMessageNotFound       = errors.New("sms message not found")
+	ErrMessagePurged         = errors.New("sms text purged after sending")
+)
+
+// PurgedMessage replaces the text of a secret message once it has been sent
+const PurgedMessage = "[purged]"
+
+// Worker sends queued SMS jobs through the provider chosen by the Router and
+// applies delivery reports. Messages that fail transiently are retried on the
+// same provider up to sms_max_attempts; messages the provider cannot deliver are
+// routed to the next available provider.
+type Worker struct {
+	db        *sql.DB
+	redis     *redis.Client
+	tracer    trace.Tracer
+	publisher *Publisher
+	router    *Router
+
+	maxAttempts    int
+	throttleLimit  int64
+	throttleWindow time.Duration
+}
+
+// NewWorker creates an SMS worker. Each recipient may receive at most
+// sms_throttle_limit messages per sms_throttle_window seconds; messages over the
+// limit are deferred to a retry queue rather than holding up a worker.
+func NewWorker(config Config, db *sql.DB, redisClient *redis.Client, tracer trace.Tracer, publisher *Publisher, router *Router) *Worker {
+	return &Worker{
+		db:             db,
+		redis:          redisClient,
+		tracer:         tracer,
+		publisher:      publisher,
+		router:         router,
+		maxAttempts:    config.MaxAttempts,
+		throttleLimit:  int64(config.ThrottleLimit),
+		throttleWindow: time.Duration(config.ThrottleWindow) * time.Second,
+	}
+}
+
+// Handle processes one SMS job. An error is returned only when the job should be retried.
+func (w *Worker) Handle(ctx context.Context, job SMSJob) error {
+	ctx, span := w.tracer.Start(ctx, "SendSMSJob",
+		trace.WithAttributes(attribute.Int64("sms_id", job.ID), attribute.String("reference", job.Reference)))
+	defer span.End()
+
+	var status models.SMSStatus
+	var providerName string
+	var attempts int
+	err := w.db.QueryRowContext(ctx, "SELECT status, COALESCE(provider, ''), attempts FROM sms_messages WHERE id = ?", job.ID).
+		Scan(&status, &providerName, &attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.WithCtx(ctx).Errorf("discarding sms job %d: message not found", job.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load sms %d: %w", job.ID, err)
+	}
+
+	// Redelivered after it was already handed to the gateway
+	if status != models.SMSStatusQueued {
+		return nil
+	}
+
+	if err := w.throttle(ctx, job.MSISDN); err != nil {
+		return err
+	}
+
+	// A message stays on the provider it was routed to unless that provider is unhealthy
+	provider, ok := w.router.Provider(providerName)
+	if !ok || !w.router.Healthy(providerName) {
+		decision, err := w.router.Route(ctx, job.MSISDN, providerName)
+		if err != nil {
+			return fmt.Errorf("failed to route sms %d: %w", job.ID, err)
+		}
+		if err := recordRoute(ctx, w.db, job.ID, models.SMSStatusQueued, decision); err != nil {
+			return fmt.Errorf("failed to record route of sms %d: %w", job.ID, err)
+		}
+		provider = decision.Provider
+	}
+	span.SetAttributes(attribute.String("provider", provider.Name()))
+
+	result, err := provider.Send(ctx, job.MSISDN, job.Message)
+	if err != nil {
+		w.router.ReportFailure(ctx, provider.Name())
+		_, _ = w.db.ExecContext(ctx, "UPDATE sms_messages SET attempts = attempts + 1, failure_reason = ? WHERE id = ?", err.Error(), job.ID)
+		return fmt.Errorf("failed to send sms %d: %w", job.ID, err)
+	}
+
+	if !result.Accepted {
+		_, err = w.db.ExecContext(ctx,
+			"UPDATE sms_messages SET status = ?, provider = ?, provider_message_id = ?, failure_reason = ?, attempts = attempts + 1 WHERE id = ?",
+			models.SMSStatusFailed, provider.Name(), result.MessageID, result.FailureReason, job.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update sms %d: %w", job.ID, err)
+		}
+		logger.WithCtx(ctx).Warnf("sms %d to %s rejected by %s: %s", job.ID, job.MSISDN, provider.Name(), result.FailureReason)
+		if result.FailureKind == FailureRecipient {
+			w.router.ReportSuccess(provider.Name())
+		} else {
+			w.router.ReportFailure(ctx, provider.Name())
+		}
+		w.recover(ctx, job.ID, job.MSISDN, provider.Name(), attempts+1, result.FailureKind)
+		return nil
+	}
+	w.router.ReportSuccess(provider.Name())
+
+	_, err = w.db.ExecContext(ctx,
+		"UPDATE sms_messages SET status = ?, provider = ?, provider_message_id = ?, failure_reason = NULL, attempts = attempts + 1, sent_at = ?, "+
+			"message = IF(secret, ?, message) WHERE id = ?",
+		models.SMSStatusSent, provider.Name(), result.MessageID, time.Now(), PurgedMessage, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update sms %d: %w", job.ID, err)
+	}
+
+	return nil
+}
+
+// HandleDeliveryReport applies a delivery report posted by the named provider,
+// or by the primary provider when no name is given. Reports for messages that
+// already reached a final status are ignored.
+func (w *Worker) HandleDeliveryReport(ctx context.Context, providerName string, form url.Values) (*DeliveryReport, error) {
+	ctx, span := w.tracer.Start(ctx, "HandleDeliveryReport",
+		trace.WithAttributes(attribute.String("provider", providerName)))
+	defer span.End()
+
+	provider := w.router.Default()
+	if providerName != "" {
+		var ok bool
+		if provider, ok = w.router.Provider(providerName); !ok {
+			return nil, ErrUnknownProvider
+		}
+	}
+
+	report, err := provider.ParseDeliveryReport(form)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDeliveryReport, err)
+	}
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var msisdn string
+	var status models.SMSStatus
+	var attempts int
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, msisdn, status, attempts FROM sms_messages WHERE provider = ? AND provider_message_id = ? FOR UPDATE",
+		provider.Name(), report.MessageID).Scan(&id, &msisdn, &status, &attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sms %s: %w", report.MessageID, err)
+	}
+
+	if status == models.SMSStatusDelivered || status == models.SMSStatusFailed {
+		return report, nil
+	}
+
+	switch report.Status {
+	case models.SMSStatusDelivered:
+		_, err = tx.ExecContext(ctx,
+			"UPDATE sms_messages SET status = ?, provider_status = ?, failure_reason = NULL, delivered_at = ? WHERE id = ?",
+			models.SMSStatusDelivered, report.ProviderStatus, time.Now(), id)
+	case models.SMSStatusFailed:
+		_, err = tx.ExecContext(ctx,
+			"UPDATE sms_messages SET status = ?, provider_status = ?, failure_reason = ? WHERE id = ?",
+			models.SMSStatusFailed, report.ProviderStatus, report.FailureReason, id)
+	default:
+		_, err = tx.ExecContext(ctx, "UPDATE sms_messages SET provider_status = ? WHERE id = ?", report.ProviderStatus, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update sms %d: %w", id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit delivery report: %w", err)
+	}
+
+	if report.Status == models.SMSStatusFailed {
+		logger.WithCtx(ctx).Warnf("sms %d not delivered by %s: %s", id, provider.Name(), report.FailureReason)
+		w.recover(ctx, id, msisdn, provider.Name(), attempts, report.FailureKind)
+	}
+
+	return report, nil
+}
+
+// recover resends a failed message when another attempt can still deliver it.
+// sms_max_attempts bounds the sends through any provider, plus one last failover.
+func (w *Worker) recover(ctx context.Context, id int64, msisdn, current string, attempts int, kind FailureKind) {
+	var decision *RouteDecision
+	var err error
+
+	switch {
+	case kind == FailureRecipient:
+	case kind == FailureTransient && attempts < w.maxAttempts:
+		decision, err = w.router.Retry(ctx, msisdn, current)
+	case attempts <= w.maxAttempts:
+		decision, err = w.router.Route(ctx, msisdn, current)
+		if decision != nil {
+			decision.Reason = fmt.Sprintf("failover from %s; %s", current, decision.Reason)
+		}
+	}
+	if errors.Is(err, ErrNoRoute) {
+		decision, err = nil, nil
+	}
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("failed to route retry of sms %d: %v", id, err)
+		return
+	}
+
+	if decision == nil {
+		logger.WithCtx(ctx).Warnf("sms %d failed permanently after %d attempts", id, attempts)
+		if err := purgeSecret(ctx, w.db, id); err != nil {
+			logger.WithCtx(ctx).Errorf("failed to purge sms %d: %v", id, err)
+		}
+		return
+	}
+
+	if err := w.publisher.Resend(ctx, id, decision); err != nil {
+		logger.WithCtx(ctx).Errorf("failed to resend sms %d via %s: %v", id, decision.Provider.Name(), err)
+		return
+	}
+	logger.WithCtx(ctx).Infof("sms %d resent via %s (%s)", id, decision.Provider.Name(), decision.Reason)
+}
+
+// throttle defers the message while the recipient is over its per-window message limit
+func (w *Worker) throttle(ctx context.Context, msisdn string) error {
+	key := fmt.Sprintf("sms:throttle:%s", msisdn)
+
+	count, err := library.IncRedisKey(w.redis, key)
+	if err != nil {
+		return err
+	}
+	if count == 1 {
+		w.redis.Expire(key, w.throttleWindow)
+	}
+	if count <= w.throttleLimit {
+		return nil
+	}
+
+	wait, err := w.redis.TTL(key).Result()
+	if err != nil || wait <= 0 {
+		// Key lost its expiry, reset it rather than deferring forever
+		w.redis.Expire(key, w.throttleWindow)
+		wait = w.throttleWindow
+	}
+
+	logger.WithCtx(ctx).Infof("sms to %s throttled for %s", msisdn, wait)
+	return connections.Deferred(wait, fmt.Sprintf("sms to %s throttled", msisdn))
+}
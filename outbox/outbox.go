@@ -0,0 +1,44 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/library"
+)
+
+// Event is a message to publish once the transaction that wrote it commits.
+// Events of the same aggregate are published in the order they were written.
+type Event struct {
+	AggregateType string
+	AggregateID   string
+	EventType     string
+	Queue         string
+	Payload       interface{}
+	Priority      connections.Priority
+	Secret        bool // the payload is purged once the event is published or given up on
+}
+
+// Enqueue writes an event to outbox_events. Pass the *sql.Tx of the business
+// change so the event is stored if and only if the change commits.
+func Enqueue(ctx context.Context, db library.Execer, event Event) error {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event.EventType, err)
+	}
+
+	// The message ID is fixed here, so a publish the relay retries is recognisably the same message
+	envelope, err := json.Marshal(connections.NewEnvelope(ctx, event.EventType))
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s envelope: %w", event.EventType, err)
+	}
+
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, queue, payload, priority, envelope, secret) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		event.AggregateType, event.AggregateID, event.EventType, event.Queue, payload, event.Priority, envelope, event.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to store %s event for %s %s: %w", event.EventType, event.AggregateType, event.AggregateID, err)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type pendingEvent struct {
+	id            int64
+	aggregateType string
+	aggregateID   string
+	eventType     string
+	queue         string
+	payload       json.RawMessage
+	priority      connections.Priority
+	envelope      []byte
+	attempts      int
+}
+
+// publisher is the part of the RabbitMQ client the relay publishes through
+type publisher interface {
+	Publish(ctx context.Context, queueName string, env connections.Envelope, payload interface{}, priority connections.Priority) error
+}
+
+// Config holds the settings of the outbox relay
+type Config struct {
+	Interval    int `mapstructure:"outbox_interval_ms"` // milliseconds between polls
+	BatchSize   int `mapstructure:"outbox_batch_size"`
+	MaxAttempts int `mapstructure:"outbox_max_attempts"`
+	MaxBackoff  int `mapstructure:"outbox_max_backoff"` // seconds
+	LagAlert    int `mapstructure:"outbox_lag_alert"`   // seconds of lag that are logged as an alert
+	Lease       int `mapstructure:"outbox_lease"`       // seconds a claimed batch is held before another relay may retry it
+}
+
+// Relay publishes pending outbox events to RabbitMQ and marks them sent.
+// Only the oldest pending event of each aggregate is eligible, so a failing
+// event holds back the later events of its aggregate until it is sent or
+// exhausts outbox_max_attempts and is marked failed. A batch is claimed for
+// outbox_lease and published outside the claiming transaction; events of a
+// relay that dies mid-batch are published again once the lease runs out.
+type Relay struct {
+	db     *sql.DB
+	rabbit publisher
+	tracer trace.Tracer
+
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	MaxBackoff  time.Duration
+	LagAlert    time.Duration
+	Lease       time.Duration
+
+	lagMillis atomic.Int64
+	pending   atomic.Int64
+
+	published metric.Int64Counter
+	failed    metric.Int64Counter
+}
+
+// NewRelay creates the outbox relay and registers its metrics:
+// outbox.lag (age of the oldest pending event), outbox.pending,
+// outbox.published and outbox.failed
+func NewRelay(config Config, db *sql.DB, rabbit *connections.RabbitMQClient, tracer trace.Tracer) (*Relay, error) {
+	r := &Relay{
+		db:          db,
+		rabbit:      rabbit,
+		tracer:      tracer,
+		Interval:    time.Duration(config.Interval) * time.Millisecond,
+		BatchSize:   config.BatchSize,
+		MaxAttempts: config.MaxAttempts,
+		MaxBackoff:  time.Duration(config.MaxBackoff) * time.Second,
+		LagAlert:    time.Duration(config.LagAlert) * time.Second,
+		Lease:       time.Duration(config.Lease) * time.Second,
+	}
+
+	meter := otel.Meter("ussd-wrapper/outbox")
+
+	var err error
+	if r.published, err = meter.Int64Counter("outbox.published",
+		metric.WithDescription("Outbox events published to RabbitMQ")); err != nil {
+		return nil, fmt.Errorf("failed to create outbox metrics: %w", err)
+	}
+	if r.failed, err = meter.Int64Counter("outbox.failed",
+		metric.WithDescription("Outbox publish attempts that failed")); err != nil {
+		return nil, fmt.Errorf("failed to create outbox metrics: %w", err)
+	}
+
+	lag, err := meter.Int64ObservableGauge("outbox.lag",
+		metric.WithDescription("Age of the oldest pending outbox event"), metric.WithUnit("ms"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox metrics: %w", err)
+	}
+	pending, err := meter.Int64ObservableGauge("outbox.pending",
+		metric.WithDescription("Outbox events waiting to be published"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox metrics: %w", err)
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(lag, r.lagMillis.Load())
+		o.ObserveInt64(pending, r.pending.Load())
+		return nil
+	}, lag, pending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register outbox metrics: %w", err)
+	}
+
+	return r, nil
+}
+
+// Lag returns the age of the oldest pending event as of the last relay pass
+func (r *Relay) Lag() time.Duration {
+	return time.Duration(r.lagMillis.Load()) * time.Millisecond
+}
+
+// Run relays events until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	logger.WithCtx(ctx).Infof("outbox relay started, polling every %s", r.Interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.WithCtx(ctx).Info("outbox relay stopped")
+			return
+		case <-ticker.C:
+			// Drain while there is work so a backlog does not wait for the next tick
+			for {
+				n, err := r.RelayOnce(ctx)
+				if err != nil {
+					logger.WithCtx(ctx).Errorf("outbox relay failed: %v", err)
+					break
+				}
+				if n == 0 {
+					break
+				}
+			}
+			if err := r.updateLag(ctx); err != nil {
+				logger.WithCtx(ctx).Errorf("failed to measure outbox lag: %v", err)
+			}
+		}
+	}
+}
+
+// RelayOnce publishes one batch and returns the number of events handled
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	ctx, span := r.tracer.Start(ctx, "RelayOutbox")
+	defer span.End()
+
+	events, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range events {
+		err := r.publish(ctx, e)
+		if err == nil {
+			_, err = r.db.ExecContext(ctx,
+				"UPDATE outbox_events SET status = 'sent', attempts = attempts + 1, last_error = NULL, sent_at = CURRENT_TIMESTAMP(3), "+
+					"payload = IF(secret, JSON_OBJECT(), payload) WHERE id = ? AND status = 'pending'", e.id)
+			if err != nil {
+				return 0, fmt.Errorf("failed to mark outbox event %d sent: %w", e.id, err)
+			}
+			r.published.Add(ctx, 1, metric.WithAttributes(attribute.String("queue", e.queue)))
+			continue
+		}
+
+		r.failed.Add(ctx, 1, metric.WithAttributes(attribute.String("queue", e.queue)))
+		if err := r.markFailedAttempt(ctx, e, err); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(events), nil
+}
+
+// claim selects a batch and pushes its next_attempt_at past the lease, so other
+// relays skip it while it is published. The row locks are only held until the claim commits.
+func (r *Relay) claim(ctx context.Context) ([]pendingEvent, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The oldest pending event of each aggregate; rows locked by another relay are skipped
+	rows, err := tx.QueryContext(ctx,
+		"SELECT o.id, o.aggregate_type, o.aggregate_id, o.event_type, o.queue, o.payload, o.priority, o.envelope, o.attempts FROM outbox_events o "+
+			"WHERE o.status = 'pending' AND o.next_attempt_at <= CURRENT_TIMESTAMP(3) AND NOT EXISTS ("+
+			"SELECT 1 FROM outbox_events p WHERE p.aggregate_type = o.aggregate_type AND p.aggregate_id = o.aggregate_id "+
+			"AND p.status = 'pending' AND p.id < o.id) "+
+			"ORDER BY o.id LIMIT ? FOR UPDATE SKIP LOCKED", r.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch outbox events: %w", err)
+	}
+
+	var events []pendingEvent
+	for rows.Next() {
+		var e pendingEvent
+		if err := rows.Scan(&e.id, &e.aggregateType, &e.aggregateID, &e.eventType, &e.queue, &e.payload, &e.priority, &e.envelope, &e.attempts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch outbox events: %w", err)
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]interface{}, 0, len(events)+1)
+	ids = append(ids, int64(r.Lease/time.Second))
+	for _, e := range events {
+		ids = append(ids, e.id)
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE outbox_events SET next_attempt_at = CURRENT_TIMESTAMP(3) + INTERVAL ? SECOND WHERE id IN (?"+strings.Repeat(", ?", len(events)-1)+")",
+		ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit outbox claim: %w", err)
+	}
+	return events, nil
+}
+
+// publish sends an event in a producer span continuing the trace the event was written in
+func (r *Relay) publish(ctx context.Context, e pendingEvent) error {
+	env := e.messageEnvelope(ctx)
+
+	carrier := make(map[string]interface{}, len(env.Trace))
+	for k, v := range env.Trace {
+		carrier[k] = v
+	}
+	ctx, span := r.tracer.Start(library.ExtractAMQPHeaders(ctx, carrier), "PublishOutboxEvent",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("queue", e.queue), attribute.String("event_type", e.eventType),
+			attribute.String("message_id", env.MessageID)))
+	defer span.End()
+
+	// The consumer's span becomes a child of this one
+	for k, v := range library.InjectAMQPHeaders(ctx) {
+		if s, ok := v.(string); ok {
+			env.Trace[k] = s
+		}
+	}
+
+	return r.rabbit.Publish(ctx, e.queue, env, e.payload, e.priority)
+}
+
+// messageEnvelope returns the envelope stored with the event. Events written before
+// envelopes were stored get a new one, continuing the relay's trace instead.
+func (e pendingEvent) messageEnvelope(ctx context.Context) connections.Envelope {
+	var env connections.Envelope
+	if len(e.envelope) > 0 && json.Unmarshal(e.envelope, &env) == nil && env.MessageID != "" {
+		if env.Trace == nil {
+			env.Trace = make(map[string]string)
+		}
+		return env
+	}
+	return connections.NewEnvelope(ctx, e.eventType)
+}
+
+// markFailedAttempt schedules a retry with exponential backoff, or gives up after MaxAttempts
+func (r *Relay) markFailedAttempt(ctx context.Context, e pendingEvent, publishErr error) error {
+	attempts := e.attempts + 1
+
+	if attempts >= r.MaxAttempts {
+		_, err := r.db.ExecContext(ctx,
+			"UPDATE outbox_events SET status = 'failed', attempts = ?, last_error = ?, payload = IF(secret, JSON_OBJECT(), payload) WHERE id = ? AND status = 'pending'",
+			attempts, publishErr.Error(), e.id)
+		if err != nil {
+			return fmt.Errorf("failed to mark outbox event %d failed: %w", e.id, err)
+		}
+		logger.WithCtx(ctx).WithField("alert", true).Errorf("outbox event %d (%s for %s %s) failed after %d attempts: %v",
+			e.id, e.eventType, e.aggregateType, e.aggregateID, attempts, publishErr)
+		return nil
+	}
+
+	backoff := time.Second << uint(attempts-1)
+	if backoff > r.MaxBackoff || backoff <= 0 {
+		backoff = r.MaxBackoff
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE outbox_events SET attempts = ?, last_error = ?, next_attempt_at = CURRENT_TIMESTAMP(3) + INTERVAL ? SECOND WHERE id = ? AND status = 'pending'",
+		attempts, publishErr.Error(), int64(backoff/time.Second), e.id)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule outbox event %d: %w", e.id, err)
+	}
+	logger.WithCtx(ctx).Warnf("outbox event %d publish failed (attempt %d), retrying in %s: %v", e.id, attempts, backoff, publishErr)
+	return nil
+}
+
+// updateLag measures the backlog for the lag metrics
+func (r *Relay) updateLag(ctx context.Context) error {
+	var count, lagMillis int64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*), COALESCE(TIMESTAMPDIFF(MICROSECOND, MIN(created_at), CURRENT_TIMESTAMP(3)) DIV 1000, 0) FROM outbox_events WHERE status = 'pending'").
+		Scan(&count, &lagMillis)
+	if err != nil {
+		return err
+	}
+
+	lag := time.Duration(lagMillis) * time.Millisecond
+	r.pending.Store(count)
+	r.lagMillis.Store(lag.Milliseconds())
+
+	if r.LagAlert > 0 && lag > r.LagAlert {
+		logger.WithCtx(ctx).WithField("alert", true).Warnf("outbox lag is %s with %d pending events", lag.Truncate(time.Second), count)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package outbox
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"ussd-wrapper/connections"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel"
+)
+
+// fakePublisher fails every publish when err is set
+type fakePublisher struct {
+	err       error
+	published int
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, queueName string, env connections.Envelope, payload interface{}, priority connections.Priority) error {
+	if p.err == nil {
+		p.published++
+	}
+	return p.err
+}
+
+func TestRelayOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		mark     string // the update that settles the claimed event
+		args     []driver.Value
+	}{
+		{"published event is marked sent", 0, nil, "UPDATE outbox_events SET status = 'sent'.* WHERE id = \\? AND status = 'pending'", []driver.Value{int64(1)}},
+		{"failed publish is retried with backoff", 2, errors.New("broker unavailable"), "UPDATE outbox_events SET attempts = \\?, last_error = \\?, next_attempt_at",
+			[]driver.Value{3, "broker unavailable", int64(4), int64(1)}},
+		{"event is failed after max attempts", 4, errors.New("broker unavailable"), "UPDATE outbox_events SET status = 'failed'",
+			[]driver.Value{5, "broker unavailable", int64(1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			relay, err := NewRelay(Config{Interval: 500, BatchSize: 100, MaxAttempts: 5, MaxBackoff: 300, LagAlert: 60, Lease: 30}, db, nil, otel.Tracer("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			publisher := &fakePublisher{err: tt.err}
+			relay.rabbit = publisher
+
+			// The claim commits before anything is published
+			mock.ExpectBegin()
+			mock.ExpectQuery("FROM outbox_events o WHERE o.status = 'pending'.* FOR UPDATE SKIP LOCKED").WithArgs(100).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "aggregate_type", "aggregate_id", "event_type", "queue", "payload", "priority", "envelope", "attempts"}).
+					AddRow(1, "sms_message", "4", "sms.send", "sms_outbound", []byte(`{}`), 5, nil, tt.attempts))
+			mock.ExpectExec("UPDATE outbox_events SET next_attempt_at = CURRENT_TIMESTAMP\\(3\\) \\+ INTERVAL \\? SECOND WHERE id IN \\(\\?\\)").
+				WithArgs(int64(30), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			mock.ExpectExec(tt.mark).WithArgs(tt.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			n, err := relay.RelayOnce(context.Background())
+			if err != nil || n != 1 {
+				t.Errorf("RelayOnce = %d, %v; want 1", n, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+	"ussd-wrapper/library/logger"
+)
+
+// CallbackTokenHeader carries the shared secret on provider callbacks
+const CallbackTokenHeader = "X-Callback-Token"
+
+// MockProvider emulates a mobile money provider for local development.
+// Deposit and payout requests are settled after a delay and reported to the callback URL,
+// a share of them fail and a share of callbacks are never sent so that the
+// timeout and status query paths can be exercised.
+type MockProvider struct {
+	delay       time.Duration
+	failureRate float64
+	dropRate    float64
+	token       string
+	client      *http.Client
+
+	mu       sync.Mutex
+	requests map[string]*Result
+}
+
+// NewMockProvider creates a mock provider that sends callbackToken on its callbacks
+func NewMockProvider(config Config, callbackToken string) *MockProvider {
+	return &MockProvider{
+		delay:       time.Duration(config.MockCallbackDelay) * time.Second,
+		failureRate: config.MockFailureRate,
+		dropRate:    config.MockCallbackDropRate,
+		token:       callbackToken,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		requests:    make(map[string]*Result),
+	}
+}
+
+func (m *MockProvider) Name() string {
+	return "mock"
+}
+
+func (m *MockProvider) InitiateDeposit(ctx context.Context, req DepositRequest) (*DepositResponse, error) {
+	providerRef := m.accept(req.Reference, req.Amount)
+
+	logger.WithCtx(ctx).Infof("mock provider: payment prompt sent to %s for %.2f %s ref %s",
+		req.MSISDN, req.Amount, req.Currency, req.Reference)
+
+	go m.settle(req.Reference, req.CallbackURL, "1032", "Request cancelled by user")
+
+	return &DepositResponse{
+		ProviderReference: providerRef,
+		Status:            StatusPending,
+		Message:           "Request accepted for processing",
+	}, nil
+}
+
+func (m *MockProvider) QueryDeposit(ctx context.Context, reference string) (*Result, error) {
+	return m.query(reference)
+}
+
+func (m *MockProvider) ParseDepositCallback(body []byte) (*Result, error) {
+	return parseMockCallback(body)
+}
+
+func (m *MockProvider) InitiatePayout(ctx context.Context, req PayoutRequest) (*PayoutResponse, error) {
+	providerRef := m.accept(req.Reference, req.Amount)
+
+	logger.WithCtx(ctx).Infof("mock provider: payout of %.2f %s to %s ref %s",
+		req.Amount, req.Currency, req.MSISDN, req.Reference)
+
+	go m.settle(req.Reference, req.CallbackURL, "2001", "The initiator information is invalid")
+
+	return &PayoutResponse{
+		ProviderReference: providerRef,
+		Status:            StatusPending,
+		Message:           "Accept the service request successfully",
+	}, nil
+}
+
+func (m *MockProvider) QueryPayout(ctx context.Context, reference string) (*Result, error) {
+	return m.query(reference)
+}
+
+func (m *MockProvider) ParsePayoutCallback(body []byte) (*Result, error) {
+	return parseMockCallback(body)
+}
+
+// accept records a new pending request and returns its provider reference
+func (m *MockProvider) accept(reference string, amount float64) string {
+	providerRef := fmt.Sprintf("MOCK%d", time.Now().UnixNano())
+
+	m.mu.Lock()
+	m.requests[reference] = &Result{
+		Reference:         reference,
+		ProviderReference: providerRef,
+		Status:            StatusPending,
+		Amount:            amount,
+	}
+	m.mu.Unlock()
+
+	return providerRef
+}
+
+func (m *MockProvider) query(reference string) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result, ok := m.requests[reference]
+	if !ok {
+		return nil, ErrUnknownReference
+	}
+
+	copied := *result
+	return &copied, nil
+}
+
+func parseMockCallback(body []byte) (*Result, error) {
+	var result Result
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid callback payload: %w", err)
+	}
+
+	if result.Reference == "" {
+		return nil, fmt.Errorf("callback payload has no reference")
+	}
+
+	return &result, nil
+}
+
+// settle resolves a pending request after the configured delay and sends the callback
+func (m *MockProvider) settle(reference, callbackURL, failureCode, failureDesc string) {
+	time.Sleep(m.delay)
+
+	m.mu.Lock()
+	result := m.requests[reference]
+	if rand.Float64() < m.failureRate {
+		result.Status = StatusFailed
+		result.ResultCode = failureCode
+		result.ResultDesc = failureDesc
+	} else {
+		result.Status = StatusCompleted
+		result.ResultCode = "0"
+		result.ResultDesc = "The service request is processed successfully"
+	}
+	payload := *result
+	m.mu.Unlock()
+
+	ctx := context.Background()
+
+	if rand.Float64() < m.dropRate {
+		logger.WithCtx(ctx).Infof("mock provider: dropping callback for %s", reference)
+		return
+	}
+
+	if callbackURL == "" {
+		return
+	}
+
+	body, _ := json.Marshal(payload)
+	httpReq, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("mock provider: failed to build callback for %s: %v", reference, err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if m.token != "" {
+		httpReq.Header.Set(CallbackTokenHeader, m.token)
+	}
+
+	res, err := m.client.Do(httpReq)
+	if err != nil {
+		logger.WithCtx(ctx).Errorf("mock provider: callback for %s failed: %v", reference, err)
+		return
+	}
+	defer res.Body.Close()
+
+	logger.WithCtx(ctx).Infof("mock provider: callback for %s delivered with status %d", reference, res.StatusCode)
+}
@@ -0,0 +1,147 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Status is the state of a payment request as reported by a provider
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// IsFinal reports whether the provider will not change the status again
+func (s Status) IsFinal() bool {
+	return s == StatusCompleted || s == StatusFailed
+}
+
+var (
+	// ErrUnknownReference is returned when the provider has no record of a payment request
+	ErrUnknownReference = errors.New("payment reference not known to provider")
+
+	// ErrRejected is wrapped by adapters when the provider declined a request, so it
+	// will never be processed. Any other error, such as a timeout or a 5xx answer,
+	// leaves the outcome unknown until a callback or status query settles it.
+	ErrRejected = errors.New("payment request rejected by provider")
+)
+
+// DepositRequest asks the provider to prompt a handset for payment (STK push)
+type DepositRequest struct {
+	Reference   string  `json:"reference"`
+	MSISDN      string  `json:"msisdn"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+	Description string  `json:"description"`
+	CallbackURL string  `json:"callback_url"`
+}
+
+// DepositResponse is the provider's synchronous answer to a deposit request
+type DepositResponse struct {
+	ProviderReference string `json:"provider_reference"`
+	Status            Status `json:"status"`
+	Message           string `json:"message"`
+}
+
+// PayoutRequest asks the provider to send money from the business account to a customer (B2C)
+type PayoutRequest struct {
+	Reference   string  `json:"reference"`
+	MSISDN      string  `json:"msisdn"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+	Remarks     string  `json:"remarks"`
+	CallbackURL string  `json:"callback_url"`
+}
+
+// PayoutResponse is the provider's synchronous answer to a payout request
+type PayoutResponse struct {
+	ProviderReference string `json:"provider_reference"`
+	Status            Status `json:"status"`
+	Message           string `json:"message"`
+}
+
+// Result is the final (or latest known) outcome of a payment request,
+// delivered either through a callback or a status query
+type Result struct {
+	Reference         string  `json:"reference"`
+	ProviderReference string  `json:"provider_reference"`
+	Status            Status  `json:"status"`
+	Amount            float64 `json:"amount,omitempty"`
+	ResultCode        string  `json:"result_code,omitempty"`
+	ResultDesc        string  `json:"result_desc,omitempty"`
+}
+
+// MobileMoneyProvider is implemented by every mobile money adapter
+type MobileMoneyProvider interface {
+	// Name identifies the provider in transaction metadata
+	Name() string
+
+	// InitiateDeposit sends a payment prompt to the customer's handset.
+	// A request the provider declines returns an error wrapping ErrRejected.
+	InitiateDeposit(ctx context.Context, req DepositRequest) (*DepositResponse, error)
+
+	// QueryDeposit fetches the current status of a deposit request
+	QueryDeposit(ctx context.Context, reference string) (*Result, error)
+
+	// ParseDepositCallback decodes the provider specific callback body
+	ParseDepositCallback(body []byte) (*Result, error)
+}
+
+// PayoutProvider is implemented by every B2C disbursement adapter
+type PayoutProvider interface {
+	// Name identifies the provider in transaction metadata
+	Name() string
+
+	// InitiatePayout sends money to the customer's mobile money account.
+	// A request the provider declines returns an error wrapping ErrRejected.
+	InitiatePayout(ctx context.Context, req PayoutRequest) (*PayoutResponse, error)
+
+	// QueryPayout fetches the current status of a payout request
+	QueryPayout(ctx context.Context, reference string) (*Result, error)
+
+	// ParsePayoutCallback decodes the provider specific result callback body
+	ParsePayoutCallback(body []byte) (*Result, error)
+}
+
+// Config selects the mobile money adapters and configures the mock provider
+type Config struct {
+	Provider       string `mapstructure:"mobile_money_provider"`
+	PayoutProvider string `mapstructure:"payout_provider"`
+
+	MockCallbackDelay    int     `mapstructure:"mock_callback_delay"` // seconds
+	MockFailureRate      float64 `mapstructure:"mock_failure_rate"`
+	MockCallbackDropRate float64 `mapstructure:"mock_callback_drop_rate"`
+}
+
+// Providers are the names mobile_money_provider and payout_provider accept
+var Providers = []string{"mock"}
+
+// NewProvider builds the mobile money provider configured in mobile_money_provider.
+// callbackToken is sent on the callbacks of providers that post them to us.
+func NewProvider(config Config, callbackToken string) (MobileMoneyProvider, error) {
+	name := strings.ToLower(config.Provider)
+
+	switch name {
+	case "mock":
+		return NewMockProvider(config, callbackToken), nil
+	default:
+		return nil, fmt.Errorf("unsupported mobile money provider %s", name)
+	}
+}
+
+// NewPayoutProvider builds the B2C provider configured in payout_provider
+func NewPayoutProvider(config Config, callbackToken string) (PayoutProvider, error) {
+	name := strings.ToLower(config.PayoutProvider)
+
+	switch name {
+	case "mock":
+		return NewMockProvider(config, callbackToken), nil
+	default:
+		return nil, fmt.Errorf("unsupported payout provider %s", name)
+	}
+}
@@ -0,0 +1,270 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/library/logger"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// errSettled is returned when a delivery that was already acked or nacked is settled again
+var errSettled = errors.New("delivery already settled")
+
+// inflight is a delivery being handled by a worker. It is acked or nacked exactly once:
+// acking twice, or after the channel was replaced, would close the consumer's channel.
+type inflight struct {
+	amqp.Delivery
+	settled atomic.Bool
+}
+
+func (d *inflight) ack() error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return errSettled
+	}
+	return d.Ack(false)
+}
+
+func (d *inflight) nack(requeue bool) error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return errSettled
+	}
+	return d.Nack(false, requeue)
+}
+
+// consumer runs the worker pool of one queue. Every worker has its own lane; a delivery
+// goes to the lane picked by its partition key, or round robin when it has none, so
+// messages sharing a key are handled one after the other in the order they arrived.
+type consumer struct {
+	qm    *Manager
+	spec  connections.QueueSpec
+	name  string
+	drain time.Duration
+
+	workers  int
+	prefetch int
+	next     int
+}
+
+func (qm *Manager) newConsumer(spec connections.QueueSpec) *consumer {
+	workers, prefetch := spec.Concurrency()
+	return &consumer{
+		qm:       qm,
+		spec:     spec,
+		name:     fmt.Sprintf("consumer-%s", spec.Name),
+		drain:    time.Duration(qm.Config.DrainTimeout) * time.Second,
+		workers:  workers,
+		prefetch: prefetch,
+	}
+}
+
+// run consumes the queue until ctx is cancelled, subscribing again whenever the
+// connection drops. It returns once in-flight deliveries have been drained.
+func (c *consumer) run(ctx context.Context, subscribed func()) {
+	for {
+		sub, err := c.subscribe(ctx)
+		if err != nil {
+			// Only cancellation ends the wait
+			return
+		}
+		if subscribed != nil {
+			subscribed()
+			subscribed = nil
+		}
+
+		c.serve(ctx, sub)
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.WithCtx(ctx).
+			WithFields(logrus.Fields{
+				"queue": c.spec.Name,
+			}).
+			Warn("Delivery channel closed, resubscribing once RabbitMQ is back")
+	}
+}
+
+// subscribe waits for the connection supervisor to connect and consumes the queue.
+// It only gives up when ctx is cancelled.
+func (c *consumer) subscribe(ctx context.Context) (*connections.Subscription, error) {
+	for {
+		if err := c.qm.RabbitClient.WaitConnected(ctx); err != nil {
+			return nil, err
+		}
+
+		sub, err := c.qm.RabbitClient.Consume(ctx, c.spec.Name, c.name, c.prefetch)
+		if err == nil {
+			return sub, nil
+		}
+
+		logger.WithCtx(ctx).
+			WithFields(logrus.Fields{
+				constants.DESCRIPTION: "failed to subscribe consumer",
+				constants.DATA:        c.spec.Name,
+			}).Error(err.Error())
+
+		// Wait a bit before trying again
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// serve dispatches the deliveries of one subscription to the workers until the
+// subscription ends or ctx is cancelled. On cancellation the broker stops sending,
+// messages a worker already started are finished and settled, and the ones still
+// waiting in a lane are requeued. The channel is closed after the drain or after the
+// drain timeout, whichever comes first; the broker requeues whatever is left unacked.
+func (c *consumer) serve(ctx context.Context, sub *connections.Subscription) {
+	// Handlers run detached from ctx so cancellation does not abort a message halfway
+	handleCtx := context.WithoutCancel(ctx)
+
+	lanes := make([]chan *inflight, c.workers)
+	var wg sync.WaitGroup
+	for i := range lanes {
+		lanes[i] = make(chan *inflight, c.prefetch)
+		wg.Add(1)
+		go func(lane <-chan *inflight) {
+			defer wg.Done()
+			c.work(ctx, handleCtx, lane)
+		}(lanes[i])
+	}
+
+dispatch:
+	for {
+		select {
+		case <-ctx.Done():
+			break dispatch
+
+		case delivery, ok := <-sub.Deliveries:
+			if !ok {
+				break dispatch
+			}
+
+			d := &inflight{Delivery: delivery}
+			select {
+			case lanes[c.lane(d.Body)] <- d:
+			case <-ctx.Done():
+				c.settle(ctx, d, d.nack(true), "nack")
+				break dispatch
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		logger.WithCtx(ctx).
+			WithFields(logrus.Fields{
+				"queue": c.spec.Name,
+			}).
+			Info("Consumer shutdown requested, draining in-flight messages")
+
+		if err := sub.Cancel(); err != nil {
+			logger.WithCtx(ctx).Warnf("Failed to cancel consumer of %s: %v", c.spec.Name, err)
+		}
+	}
+
+	for _, lane := range lanes {
+		close(lane)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(c.drain):
+		logger.WithCtx(ctx).
+			WithFields(logrus.Fields{
+				"queue": c.spec.Name,
+			}).
+			Warnf("Consumer did not drain within %s, leaving the rest to be redelivered", c.drain)
+	}
+
+	sub.Close()
+}
+
+// work handles the deliveries of one lane in order. Once ctx is cancelled the
+// deliveries that have not been started are requeued instead.
+func (c *consumer) work(ctx, handleCtx context.Context, lane <-chan *inflight) {
+	for d := range lane {
+		if ctx.Err() != nil {
+			c.settle(ctx, d, d.nack(true), "nack")
+			continue
+		}
+
+		err := c.qm.RouteMessage(handleCtx, d.Delivery, c.spec.Name)
+		if err != nil {
+			c.qm.handleFailure(handleCtx, d, c.spec.Name, err)
+			continue
+		}
+
+		c.settle(handleCtx, d, d.ack(), "ack")
+	}
+}
+
+// lane picks the worker for a message body: by partition key when the queue has one
+// and the body carries it, round robin otherwise
+func (c *consumer) lane(body []byte) int {
+	if c.workers == 1 {
+		return 0
+	}
+
+	if key, ok := partitionKey(body, c.spec.PartitionKey); ok {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return int(h.Sum32() % uint32(c.workers))
+	}
+
+	c.next = (c.next + 1) % c.workers
+	return c.next
+}
+
+func (c *consumer) settle(ctx context.Context, d *inflight, err error, action string) {
+	if err == nil {
+		return
+	}
+	logger.WithCtx(ctx).
+		WithFields(logrus.Fields{
+			constants.DESCRIPTION: "failed to " + action + " message",
+			constants.DATA:        c.spec.Name,
+			"delivery_tag":        d.DeliveryTag,
+		}).Error(err.Error())
+}
+
+// partitionKey returns the value of a top level field of a JSON body as a string
+func partitionKey(body []byte, field string) (string, bool) {
+	if field == "" {
+		return "", false
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", false
+	}
+
+	raw, ok := fields[field]
+	if !ok || string(raw) == "null" {
+		return "", false
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, s != ""
+	}
+	return string(raw), true
+}
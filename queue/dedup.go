@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/inbox"
+	"ussd-wrapper/library/logger"
+)
+
+// processedKey is the Redis key marking messageID as processed by the consumer of queue
+func processedKey(queue, messageID string) string {
+	return fmt.Sprintf("queue:processed:%s:%s", queue, messageID)
+}
+
+// alreadyProcessed reports whether the consumer of spec has processed messageID. Redis is
+// checked first; database deduplicated queues fall back to processed_messages, whose
+// answer is authoritative. A Redis outage lets the message through rather than stalling.
+func (qm *Manager) alreadyProcessed(ctx context.Context, spec connections.QueueSpec, messageID string) (bool, error) {
+	if messageID == "" || spec.Deduplicate == connections.DeduplicateNone {
+		return false, nil
+	}
+
+	n, err := qm.RedisConn.Exists(processedKey(spec.Name, messageID)).Result()
+	if err != nil {
+		logger.WithCtx(ctx).Warnf("failed to check message %s in redis: %v", messageID, err)
+	} else if n > 0 {
+		return true, nil
+	}
+
+	if spec.Deduplicate != connections.DeduplicateDatabase {
+		return false, nil
+	}
+	return inbox.Processed(ctx, qm.DB, spec.Name, messageID)
+}
+
+// claim returns the context to handle messageID in. Handlers of database deduplicated
+// queues record the message with inbox.Record in the transaction of their change.
+func (qm *Manager) claim(ctx context.Context, spec connections.QueueSpec, messageID string) context.Context {
+	if messageID == "" || spec.Deduplicate != connections.DeduplicateDatabase {
+		return ctx
+	}
+	return inbox.WithClaim(ctx, spec.Name, messageID)
+}
+
+// markProcessed remembers a handled message. The handler succeeded, so a failure
+// here is only logged: the worst case is a later duplicate that is handled again.
+func (qm *Manager) markProcessed(ctx context.Context, spec connections.QueueSpec, messageID string) {
+	if messageID == "" || spec.Deduplicate == connections.DeduplicateNone {
+		return
+	}
+
+	if spec.Deduplicate == connections.DeduplicateDatabase {
+		// Covers handlers that finished without a transaction to record in
+		if err := inbox.MarkProcessed(ctx, qm.DB, spec.Name, messageID); err != nil {
+			logger.WithCtx(ctx).Errorf("failed to mark message %s processed: %v", messageID, err)
+		}
+	}
+
+	ttl := time.Duration(qm.Config.DedupTTL) * time.Second
+	if err := qm.RedisConn.Set(processedKey(spec.Name, messageID), "1", ttl).Err(); err != nil {
+		logger.WithCtx(ctx).Warnf("failed to mark message %s processed in redis: %v", messageID, err)
+	}
+}
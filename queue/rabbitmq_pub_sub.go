@@ -3,15 +3,16 @@ package queue
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"os"
 	"sort"
-	"strings"
+	"sync"
 	"sync/atomic"
-	"time"
 	"ussd-wrapper/connections"
+	"ussd-wrapper/inbox"
+	"ussd-wrapper/library"
 	"ussd-wrapper/library/logger"
 
 	"github.com/sirupsen/logrus"
@@ -21,251 +22,233 @@ import (
 	"ussd-wrapper/constants"
 )
 
+// Config holds the consumer settings of the queue manager
+type Config struct {
+	DrainTimeout int `mapstructure:"queue_drain_timeout"` // seconds a stopping consumer waits for in-flight messages
+	DedupTTL     int `mapstructure:"queue_dedup_ttl"`     // seconds processed message IDs are remembered in redis
+	// Days processed message IDs are kept in the database
+	RetentionDays int `mapstructure:"processed_message_retention_days"`
+}
+
 // Manager manages multiple RabbitMQ consumers
 type Manager struct {
+	Config       Config
 	DB           *sql.DB
 	DBSlave      *sql.DB
 	Tracer       trace.Tracer
 	RedisConn    *redis.Client
 	RabbitClient *connections.RabbitMQClient
+	Handlers     *Registry
+
+	// spec of every consumed queue, from the topology
+	consumers map[string]connections.QueueSpec
 
 	TotalConfigured int32 // total intended queues
 	TotalStarted    int32 // atomic counter
 }
 
-// NewQueueManager creates a new queue manager instance
-func NewQueueManager(tracer trace.Tracer, db *sql.DB, dbSlave *sql.DB, redis *redis.Client) (*Manager, error) {
-	// Get the RabbitMQ client
-	rabbitClient := connections.GetClient()
-	if rabbitClient == nil {
-		return nil, fmt.Errorf("failed to get RabbitMQ client")
+// NewQueueManager creates a new queue manager instance. The client must be using a
+// topology, and every queue the topology gives a handler must have one in handlers.
+func NewQueueManager(config Config, tracer trace.Tracer, db *sql.DB, dbSlave *sql.DB, redis *redis.Client, rabbitClient *connections.RabbitMQClient, handlers *Registry) (*Manager, error) {
+	topology := rabbitClient.Topology()
+	if topology == nil {
+		return nil, fmt.Errorf("RabbitMQ client has no topology")
+	}
+
+	consumers := make(map[string]connections.QueueSpec)
+	var channels []string
+	for _, q := range topology.ConsumedQueues() {
+		consumers[q.Name] = q
+		channels = append(channels, q.Handler)
+	}
+	if err := handlers.Check(channels); err != nil {
+		return nil, err
 	}
 
 	return &Manager{
+		Config:       config,
 		DB:           db,
 		DBSlave:      dbSlave,
 		Tracer:       tracer,
 		RedisConn:    redis,
 		RabbitClient: rabbitClient,
+		Handlers:     handlers,
+		consumers:    consumers,
 	}, nil
 }
 
-// InitializeQueues sets up all configured consumer queues
+// InitializeQueues consumes all configured queues until ctx is cancelled, and returns
+// once every consumer has drained its in-flight messages
 func (qm *Manager) InitializeQueues(ctx context.Context) {
 	ctx, span := qm.Tracer.Start(ctx, "InitQueues")
 	defer span.End()
 
-	// Get configuration from environment
-	queues := os.Getenv("queues")
-	channels := os.Getenv("channels")
-
-	// Get all the queues to consume from
-	parts := strings.Split(queues, ",")
-	sort.Strings(parts)
-
-	partsChannels := strings.Split(channels, ",")
-	sort.Strings(partsChannels)
-
-	qm.TotalConfigured = int32(len(parts) * len(partsChannels)) // total expected queues
-
-	for _, table := range partsChannels {
-		channel := strings.ToLower(strings.TrimSpace(table))
+	// Consume every queue the topology gives a handler
+	queues := make([]string, 0, len(qm.consumers))
+	for queueName := range qm.consumers {
+		queues = append(queues, queueName)
+	}
+	sort.Strings(queues)
 
-		for _, action := range parts {
-			action = strings.ToLower(strings.TrimSpace(action))
-			queueName := fmt.Sprintf("%s.%s", channel, action)
+	qm.TotalConfigured = int32(len(queues)) // total expected queues
 
-			go qm.SetupQueue(ctx, queueName)
-		}
+	var consumers sync.WaitGroup
+	for _, queueName := range queues {
+		consumers.Add(1)
+		go func(queueName string) {
+			defer consumers.Done()
+			qm.SetupQueue(ctx, queueName)
+		}(queueName)
 	}
 
-	// Block forever to keep consumers running
-	select {}
+	consumers.Wait()
+	logger.WithCtx(ctx).Info("All consumers stopped")
 }
 
-// SetupQueue configures and starts a consumer for a specific queue
+// SetupQueue starts the worker pool of a queue and consumes it until ctx is cancelled
 func (qm *Manager) SetupQueue(ctx context.Context, queueName string) {
 	ctx, span := qm.Tracer.Start(ctx, "SetupQueue",
 		trace.WithAttributes(attribute.String("queueName", queueName)))
 	defer span.End()
 
-	// Create a unique consumer name for each queue
-	consumerName := fmt.Sprintf("consumer-%s", queueName)
+	c := qm.newConsumer(qm.consumers[queueName])
+	c.run(ctx, func() {
+		// Update started counter
+		started := atomic.AddInt32(&qm.TotalStarted, 1)
+
+		logger.WithCtx(ctx).
+			WithFields(logrus.Fields{
+				"queue":         queueName,
+				"workers":       c.workers,
+				"prefetch":      c.prefetch,
+				"started_total": started,
+				"of_configured": qm.TotalConfigured,
+			}).
+			Info("✅ Queue started")
+	})
+}
 
-	// Start consuming messages
-	deliveries, err := qm.RabbitClient.Consume(ctx, queueName, consumerName)
-	if err != nil {
-		logger.WithCtx(ctx).WithFields(
-			logrus.Fields{
-				constants.DESCRIPTION: "error setting up consumer",
-				constants.DATA:        queueName,
-			}).Panic()
+// handleFailure schedules a failed delivery for a delayed retry or moves it to the
+// queue's DLQ, then acks the original. Deferred messages wait in a retry queue
+// without using up an attempt. Messages without a handler, with a body that
+// cannot be decoded or of an unsupported schema version are dead-lettered straight
+// away since retrying cannot help.
+func (qm *Manager) handleFailure(ctx context.Context, delivery *inflight, queueName string, cause error) {
+	fields := logrus.Fields{
+		constants.DESCRIPTION: "error processing message",
+		constants.DATA:        queueName,
+		"error":               cause.Error(),
+		"attempt":             connections.HeaderInt(delivery.Headers, connections.HeaderAttempts) + 1,
+		"message_id":          delivery.MessageId,
+		"correlation_id":      delivery.CorrelationId,
 	}
 
-	// Update started counter
-	started := atomic.AddInt32(&qm.TotalStarted, 1)
-
-	logger.WithCtx(ctx).
-		WithFields(logrus.Fields{
-			"queue":         queueName,
-			"started_total": started,
-			"of_configured": qm.TotalConfigured,
-		}).
-		Info("✅ Queue started")
-
-	// Start processing messages
-	go qm.processDeliveries(ctx, deliveries, queueName)
-
-	// Block this goroutine
-	forever := make(chan bool)
-	<-forever
-}
+	var deadLettered, deferred bool
+	var err error
+	if errors.Is(cause, ErrNoHandler) || errors.Is(cause, ErrMalformedMessage) || errors.Is(cause, connections.ErrUnsupportedVersion) {
+		deadLettered, err = true, qm.RabbitClient.DeadLetter(ctx, queueName, delivery.Delivery, cause)
+	} else if errors.Is(cause, connections.ErrDeferred) {
+		deferred, err = true, qm.RabbitClient.Defer(ctx, queueName, delivery.Delivery, cause)
+	} else {
+		deadLettered, err = qm.RabbitClient.Retry(ctx, queueName, delivery.Delivery, cause)
+	}
 
-// processDeliveries handles incoming messages from a queue
-func (qm *Manager) processDeliveries(ctx context.Context, deliveries <-chan amqp.Delivery, queueName string) {
-	for {
-		select {
-		case <-ctx.Done():
-			logrus.WithContext(ctx).
+	if err != nil {
+		// Without a retry queue the broker has to redeliver it
+		logger.WithCtx(ctx).WithFields(fields).Errorf("Failed to schedule retry: %v", err)
+		if err := delivery.nack(true); err != nil {
+			logger.WithCtx(ctx).
 				WithFields(logrus.Fields{
-					"queue": queueName,
-				}).
-				Info("Consumer shutdown requested")
-			return
-
-		case delivery, ok := <-deliveries:
-			if !ok {
-				logger.WithCtx(ctx).
-					WithFields(logrus.Fields{
-						"queue": queueName,
-					}).
-					Warn("Delivery channel closed, attempting to reconnect")
-
-				// Channel was closed, attempt to reconnect
-				newCtx := context.Background() // Create a new context since the old one might be canceled
-				newDeliveries, err := qm.RabbitClient.Consume(newCtx, queueName, fmt.Sprintf("consumer-%s", queueName))
-				if err != nil {
-					logger.WithCtx(newCtx).
-						WithFields(logrus.Fields{
-							constants.DESCRIPTION: "failed to reconnect consumer",
-							constants.DATA:        queueName,
-						}).Error(err.Error())
-
-					// Wait a bit before trying again
-					time.Sleep(5 * time.Second)
-					continue
-				}
-
-				// Replace deliveries channel and continue
-				deliveries = newDeliveries
-				continue
-			}
-
-			// Process the delivery
-			err := qm.RouteMessage(ctx, delivery, queueName)
-			if err != nil {
-				logger.WithCtx(ctx).
-					WithFields(logrus.Fields{
-						constants.DESCRIPTION: "error processing message",
-						constants.DATA:        queueName,
-						"error":               err.Error(),
-					}).Error("Failed to process message")
-
-				// Nack the message to requeue it
-				if err := delivery.Nack(false, true); err != nil {
-					logger.WithCtx(ctx).
-						WithFields(logrus.Fields{
-							constants.DESCRIPTION: "failed to nack message",
-							constants.DATA:        queueName,
-						}).Error(err.Error())
-				}
-			} else {
-				// Ack the message
-				if err := delivery.Ack(false); err != nil {
-					logger.WithCtx(ctx).
-						WithFields(logrus.Fields{
-							constants.DESCRIPTION: "failed to ack message",
-							constants.DATA:        queueName,
-						}).Error(err.Error())
-				}
-			}
+					constants.DESCRIPTION: "failed to nack message",
+					constants.DATA:        queueName,
+				}).Error(err.Error())
 		}
+		return
+	}
+
+	if deadLettered {
+		fields["alert"] = true
+		logger.WithCtx(ctx).WithFields(fields).Error("Message moved to dead letter queue")
+	} else if deferred {
+		logger.WithCtx(ctx).WithFields(fields).Info("Message deferred")
+	} else {
+		logger.WithCtx(ctx).WithFields(fields).Warn("Message scheduled for retry")
+	}
+
+	if err := delivery.ack(); err != nil {
+		logger.WithCtx(ctx).
+			WithFields(logrus.Fields{
+				constants.DESCRIPTION: "failed to ack message",
+				constants.DATA:        queueName,
+			}).Error(err.Error())
 	}
 }
 
-// RouteMessage determines how to process a message based on the queue name
+// RouteMessage hands a delivery to the handler registered for its queue, unless the
+// message was processed already. The handler runs in a consumer span continuing the
+// producer's trace, with the envelope in its context so the messages it publishes are
+// correlated with this one.
 func (qm *Manager) RouteMessage(ctx context.Context, delivery amqp.Delivery, queue string) error {
-	ctx, span := qm.Tracer.Start(ctx, "RouteMessage",
-		trace.WithAttributes(attribute.String("queueName", queue)))
+	ctx, span := qm.Tracer.Start(library.ExtractAMQPHeaders(ctx, delivery.Headers), "RouteMessage",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("queueName", queue), attribute.String("messageId", delivery.MessageId),
+			attribute.String("messageType", delivery.Type), attribute.String("correlationId", delivery.CorrelationId)))
 	defer span.End()
 
-	prefix := os.Getenv("queue_prefix")
+	env, err := connections.EnvelopeOf(delivery)
+	if err != nil {
+		return err
+	}
+	ctx = connections.ContextWithEnvelope(ctx, env)
 
-	if len(prefix) > 0 {
-		parts := strings.Split(queue, ".")
-		if len(parts) > 1 {
-			queue = strings.Join(parts[1:], ".")
-		}
+	spec, ok := qm.consumers[queue]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoHandler, queue)
 	}
 
-	queue = strings.ToLower(queue)
-
-	// Create a slice with a single delivery to maintain compatibility with existing code
-	deliveries := make(chan amqp.Delivery, 1)
-	deliveries <- delivery
-	close(deliveries)
-
-	if queue == "bet_settlement" {
-		return qm.ProcessSettlement(ctx, deliveries, queue)
-	} else if strings.HasPrefix(queue, "bet_settlement") {
-		return qm.ProcessSettlement(ctx, deliveries, queue)
-	} else if queue == "rollback_bet_settlement" {
-		return qm.ProcessSettlementRollback(ctx, deliveries)
-	} else if queue == "bet_settlement_rollback" {
-		return qm.ProcessSettlementRollback(ctx, deliveries)
-	} else if queue == "bet_closure" {
-		return qm.ProcessBetClosure(ctx, deliveries)
-	} else if queue == "bet_approval" {
-		return qm.ProcessBetApproval(ctx, deliveries)
+	handler, err := qm.Handlers.Handler(spec.Handler)
+	if err != nil {
+		return err
 	}
 
+	// Deliveries are at least once; a message handled before a crash kept it from being acked comes back
+	processed, err := qm.alreadyProcessed(ctx, spec, env.MessageID)
+	if err != nil {
+		return err
+	}
+	if processed {
+		span.SetAttributes(attribute.Bool("duplicate", true))
+		logger.WithCtx(ctx).Infof("skipping duplicate message %s on %s", env.MessageID, queue)
+		return nil
+	}
+
+	err = handler.Handle(qm.claim(ctx, spec, env.MessageID), delivery.Body)
+	if errors.Is(err, inbox.ErrDuplicate) {
+		// Another delivery of the message committed first
+		logger.WithCtx(ctx).Infof("message %s on %s was processed concurrently", env.MessageID, queue)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	qm.markProcessed(ctx, spec, env.MessageID)
 	return nil
 }
 
-// PublishMessage sends a message to a RabbitMQ queue
-func (qm *Manager) PublishMessage(ctx context.Context, queueName string, payload interface{}, priority uint8) error {
+// PublishMessage sends a message of messageType to a RabbitMQ queue with one of the
+// connections.Priority levels. The envelope carries the trace and correlation of ctx.
+func (qm *Manager) PublishMessage(ctx context.Context, queueName, messageType string, payload interface{}, priority connections.Priority) error {
 	ctx, span := qm.Tracer.Start(ctx, "PublishMessage",
-		trace.WithAttributes(attribute.String("queueName", queueName)))
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("queueName", queueName), attribute.String("messageType", messageType),
+			attribute.String("priority", priority.String())))
 	defer span.End()
 
-	err := qm.RabbitClient.Publish(ctx, queueName, payload, priority)
+	err := qm.RabbitClient.Publish(ctx, queueName, connections.NewEnvelope(ctx, messageType), payload, priority)
 	if err != nil {
 		return fmt.Errorf("failed to publish message to queue %s: %w", queueName, err)
 	}
 
 	return nil
 }
-
-// ProcessSettlement handles bet settlement messages
-func (qm *Manager) ProcessSettlement(ctx context.Context, deliveries <-chan amqp.Delivery, queue string) error {
-	// Implementation would go here
-	return nil
-}
-
-// ProcessSettlementRollback handles bet settlement rollback messages
-func (qm *Manager) ProcessSettlementRollback(ctx context.Context, deliveries <-chan amqp.Delivery) error {
-	// Implementation would go here
-	return nil
-}
-
-// ProcessBetClosure handles bet closure messages
-func (qm *Manager) ProcessBetClosure(ctx context.Context, deliveries <-chan amqp.Delivery) error {
-	// Implementation would go here
-	return nil
-}
-
-// ProcessBetApproval handles bet approval messages
-func (qm *Manager) ProcessBetApproval(ctx context.Context, deliveries <-chan amqp.Delivery) error {
-	// Implementation would go here
-	return nil
-}
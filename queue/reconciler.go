@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/constants"
+	"ussd-wrapper/inbox"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/payments"
+	"ussd-wrapper/wallet"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReconcilerConfig holds the settings of the reconciler
+type ReconcilerConfig struct {
+	Interval      int      `mapstructure:"reconcile_interval"`    // seconds between runs
+	StaleAfter    int      `mapstructure:"reconcile_stale_after"` // seconds
+	DepositExpiry int      `mapstructure:"deposit_expiry"`        // seconds
+	MaxAttempts   int      `mapstructure:"reconcile_max_attempts"`
+	BatchSize     int      `mapstructure:"reconcile_batch_size"`
+	AlertMSISDNs  []string `mapstructure:"ops_alert_msisdns"` // notified by SMS when a transaction is escalated
+}
+
+// Reconciler resolves transactions left pending by provider callbacks that never arrived
+type Reconciler struct {
+	Tracer   trace.Tracer
+	Wallet   *wallet.Service
+	Notifier notifications.Sender
+
+	Interval      time.Duration // how often to look for stale transactions
+	StaleAfter    time.Duration // age after which a pending transaction is reconciled
+	DepositExpiry time.Duration // age after which an unresolved deposit is failed
+	MaxAttempts   int           // attempts before a transaction is escalated
+	BatchSize     int
+	AlertMSISDNs  []string
+}
+
+// NewReconciler creates a reconciler
+func NewReconciler(config ReconcilerConfig, tracer trace.Tracer, walletService *wallet.Service, notifier notifications.Sender) *Reconciler {
+	return &Reconciler{
+		Tracer:        tracer,
+		Wallet:        walletService,
+		Notifier:      notifier,
+		Interval:      time.Duration(config.Interval) * time.Second,
+		StaleAfter:    time.Duration(config.StaleAfter) * time.Second,
+		DepositExpiry: time.Duration(config.DepositExpiry) * time.Second,
+		MaxAttempts:   config.MaxAttempts,
+		BatchSize:     config.BatchSize,
+		AlertMSISDNs:  config.AlertMSISDNs,
+	}
+}
+
+// ReconcileRequest asks for one pending transaction to be reconciled ahead of the periodic run
+type ReconcileRequest struct {
+	Reference string `json:"reference"`
+}
+
+// HandleRequest reconciles the transaction of a queued request. Transactions that are
+// no longer pending are skipped, and failures are left to the periodic run.
+func (r *Reconciler) HandleRequest(ctx context.Context, req ReconcileRequest) error {
+	p, err := r.Wallet.GetPendingTransaction(ctx, req.Reference)
+	if errors.Is(err, wallet.ErrTransactionNotFound) {
+		logger.WithCtx(ctx).Infof("skipping reconcile request for %s: not pending", req.Reference)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.Reconcile(ctx, *p)
+	if errors.Is(err, inbox.ErrDuplicate) {
+		return err
+	}
+	if err != nil {
+		logger.WithCtx(ctx).
+			WithFields(logrus.Fields{
+				constants.DESCRIPTION: "failed to reconcile transaction",
+				constants.DATA:        p.Reference,
+			}).Error(err.Error())
+	}
+	return nil
+}
+
+// Run reconciles stale pending transactions until ctx is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	logger.WithCtx(ctx).Infof("✅ Reconciler started, interval %s", r.Interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.WithCtx(ctx).Info("Reconciler shutdown requested")
+			return
+		case <-ticker.C:
+			if err := r.ReconcileOnce(ctx); err != nil {
+				logger.WithCtx(ctx).Errorf("reconciliation run failed: %v", err)
+			}
+		}
+	}
+}
+
+// ReconcileOnce processes one batch of stale pending transactions
+func (r *Reconciler) ReconcileOnce(ctx context.Context) error {
+	ctx, span := r.Tracer.Start(ctx, "ReconcileOnce")
+	defer span.End()
+
+	pending, err := r.Wallet.ListStalePending(ctx, r.StaleAfter, r.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("pending", len(pending)))
+
+	for _, p := range pending {
+		if err := r.Reconcile(ctx, p); err != nil {
+			logger.WithCtx(ctx).
+				WithFields(logrus.Fields{
+					constants.DESCRIPTION: "failed to reconcile transaction",
+					constants.DATA:        p.Reference,
+				}).Error(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Reconcile queries the provider for one pending transaction and applies the outcome
+func (r *Reconciler) Reconcile(ctx context.Context, p wallet.PendingTransaction) error {
+	ctx, span := r.Tracer.Start(ctx, "Reconcile",
+		trace.WithAttributes(attribute.String("reference", p.Reference), attribute.String("type", string(p.Type))))
+	defer span.End()
+
+	attempt := wallet.ReconcileAttempt{At: time.Now()}
+
+	result, err := r.Wallet.QueryProviderStatus(ctx, p)
+	if err != nil {
+		attempt.Status = "error"
+		attempt.Detail = err.Error()
+	} else {
+		attempt.Status = string(result.Status)
+		attempt.Detail = result.ResultDesc
+	}
+
+	// A deposit the provider still reports as unresolved, or has no record of, past its
+	// expiry never took the customer's money, so it is safe to fail. A failed query says
+	// nothing about the deposit; it is retried and escalated like any other.
+	expired := p.Type == models.TransactionTypeDeposit && time.Since(p.CreatedAt) > r.DepositExpiry
+	if expired && err == nil && !result.Status.IsFinal() {
+		result = &payments.Result{
+			Reference:  p.Reference,
+			Status:     payments.StatusFailed,
+			ResultDesc: "no callback received before expiry",
+		}
+		attempt.Status = string(payments.StatusFailed)
+		attempt.Detail = result.ResultDesc
+	}
+
+	final := result != nil && result.Status.IsFinal()
+	escalate := !final && !p.Escalated && p.Attempts+1 >= r.MaxAttempts
+
+	attempts, recordErr := r.Wallet.RecordReconcileAttempt(ctx, p.ID, attempt, escalate)
+	if recordErr != nil {
+		return recordErr
+	}
+
+	if final {
+		if err := r.Wallet.ApplyProviderResult(ctx, p, *result); err != nil {
+			return fmt.Errorf("failed to apply %s result: %w", result.Status, err)
+		}
+		logger.WithCtx(ctx).Infof("reconciled %s %s as %s after %d attempts", p.Type, p.Reference, result.Status, attempts)
+		return nil
+	}
+
+	if escalate {
+		r.alert(ctx, p, attempts, attempt.Detail)
+	}
+
+	return err
+}
+
+// alert escalates a transaction that could not be reconciled automatically
+func (r *Reconciler) alert(ctx context.Context, p wallet.PendingTransaction, attempts int, detail string) {
+	message := fmt.Sprintf("ALERT: %s %s still pending after %d reconciliation attempts (%s)", p.Type, p.Reference, attempts, detail)
+
+	logger.WithCtx(ctx).
+		WithFields(logrus.Fields{
+			constants.DESCRIPTION: "reconciliation escalated",
+			constants.DATA:        p.Reference,
+			"alert":               true,
+			"attempts":            attempts,
+		}).Error(message)
+
+	for _, msisdn := range r.AlertMSISDNs {
+		msisdn = strings.TrimSpace(msisdn)
+		if msisdn == "" {
+			continue
+		}
+		if err := r.Notifier.SendSMS(ctx, msisdn, message, p.Reference, connections.PriorityCritical); err != nil {
+			logger.WithCtx(ctx).Errorf("failed to send reconciliation alert to %s: %v", msisdn, err)
+		}
+	}
+}
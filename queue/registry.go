@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNoHandler is returned for deliveries from a queue whose channel has no registered handler
+	ErrNoHandler = errors.New("no handler registered for queue")
+
+	// ErrMalformedMessage is returned when a delivery body cannot be decoded for its handler
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// Handler processes the body of one delivery. A returned error means the delivery was not handled.
+type Handler interface {
+	Handle(ctx context.Context, body []byte) error
+}
+
+// JSONHandler is a Handler that decodes the JSON body into T before calling the function
+type JSONHandler[T any] func(ctx context.Context, msg T) error
+
+// Handle decodes the body and passes it on
+func (h JSONHandler[T]) Handle(ctx context.Context, body []byte) error {
+	var msg T
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	return h(ctx, msg)
+}
+
+// Registry maps channels to handlers. The topology names the channel whose
+// handler consumes each queue, so one handler can serve several queues.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewRegistry creates an empty handler registry
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]Handler)}
+}
+
+// Register adds the handler of a channel. Registering a channel twice is a wiring bug and panics.
+func (r *Registry) Register(channel string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	channel = strings.ToLower(strings.TrimSpace(channel))
+	if _, ok := r.handlers[channel]; ok {
+		panic(fmt.Sprintf("queue handler for %s registered twice", channel))
+	}
+	r.handlers[channel] = handler
+}
+
+// Handler returns the handler registered for a channel
+func (r *Registry) Handler(channel string) (Handler, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	handler, ok := r.handlers[strings.ToLower(channel)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoHandler, channel)
+	}
+	return handler, nil
+}
+
+// Channels returns the registered channels in order
+func (r *Registry) Channels() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.channelsLocked()
+}
+
+// Check fails when any of the configured channels has no handler, so a typo or
+// a stale channel stops startup instead of dead-lettering its messages
+func (r *Registry) Check(channels []string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var missing []string
+	for _, channel := range channels {
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		if channel == "" {
+			continue
+		}
+		if _, ok := r.handlers[channel]; !ok {
+			missing = append(missing, channel)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s (registered: %s)", ErrNoHandler, strings.Join(missing, ", "), strings.Join(r.channelsLocked(), ", "))
+	}
+	return nil
+}
+
+func (r *Registry) channelsLocked() []string {
+	channels := make([]string, 0, len(r.handlers))
+	for channel := range r.handlers {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
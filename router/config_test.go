@@ -0,0 +1,35 @@
+package router
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProductionConfig(t *testing.T) {
+	for key, value := range map[string]string{
+		"APP_ENV":                "production",
+		"DATABASE_HOST":          "db",
+		"DATABASE_USERNAME":      "ussd",
+		"DATABASE_NAME":          "ussd",
+		"PAYMENT_CALLBACK_URL":   "https://ussd.example.com/webhooks/payment-notification",
+		"PAYOUT_CALLBACK_URL":    "https://ussd.example.com/webhooks/payout-result",
+		"WITHDRAWAL_CODE_SECRET": "0123456789abcdef0123456789abcdef",
+		"PAYMENT_CALLBACK_TOKEN": "payment-token",
+		"SMS_CALLBACK_TOKEN":     "sms-token",
+	} {
+		t.Setenv(key, value)
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig = %v", err)
+	}
+	if len(config.Warnings()) != 1 {
+		t.Errorf("Warnings = %q, want one about the mock provider", config.Warnings())
+	}
+
+	config.Controller.PaymentCallbackToken = ""
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Validate without payment_callback_token = %v, want %v", err, ErrInvalidConfig)
+	}
+}
@@ -0,0 +1,350 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/library/logger"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// Schema check failures; the app refuses to start on any of them
+var (
+	ErrSchemaDirty  = errors.New("schema is dirty")
+	ErrSchemaBehind = errors.New("schema is behind this build")
+	ErrSchemaAhead  = errors.New("schema is ahead of this build")
+)
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// migration is a numbered pair of files in the migrations directory
+type migration struct {
+	version uint
+	name    string
+	up      bool
+	down    bool
+}
+
+// listMigrations reads the migrations directory under rootPath, lowest version first
+func listMigrations(rootPath string) ([]migration, error) {
+	entries, err := os.ReadDir(filepath.Join(rootPath, "migrations"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[uint]*migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[uint(version)]
+		if !ok {
+			mig = &migration{version: uint(version), name: match[2]}
+			byVersion[uint(version)] = mig
+		}
+		if match[3] == "up" {
+			mig.up = true
+		} else {
+			mig.down = true
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// newMigrator reads the migrations directory under rootPath
+func newMigrator(db *sql.DB, rootPath string) (*migrate.Migrate, error) {
+	driver, err := mysql.WithInstance(db, &mysql.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("migration driver setup failed: %w", err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file:///%s/migrations", rootPath), "mysql", driver)
+	if err != nil {
+		return nil, fmt.Errorf("migration setup failed: %w", err)
+	}
+	return m, nil
+}
+
+// schemaVersion returns the applied version, 0 when no migration has been applied
+func schemaVersion(m *migrate.Migrate) (uint, bool, error) {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+// migrateUp applies every pending migration
+func migrateUp(ctx context.Context, db *sql.DB, rootPath string) error {
+	m, err := newMigrator(db, rootPath)
+	if err != nil {
+		return err
+	}
+
+	err = m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		logger.WithCtx(ctx).Info("Migrations up to date")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	version, _, _ := m.Version()
+	logger.WithCtx(ctx).Infof("✅ Migrated to version %d", version)
+	return nil
+}
+
+// checkSchema makes sure the database is at the latest migration of this build and
+// clean. A dirty schema needs fixing by hand and `migrate force`; a schema behind
+// needs `migrate up`; a schema ahead means an older build is running on it.
+func checkSchema(ctx context.Context, db *sql.DB, rootPath string) error {
+	migrations, err := listMigrations(rootPath)
+	if err != nil {
+		return err
+	}
+	m, err := newMigrator(db, rootPath)
+	if err != nil {
+		return err
+	}
+	version, dirty, err := schemaVersion(m)
+	if err != nil {
+		return err
+	}
+
+	var latest uint
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].version
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("%w at version %d: fix the failed migration by hand, then run `migrate force %d`", ErrSchemaDirty, version, version)
+	case version < latest:
+		return fmt.Errorf("%w: version %d, want %d; run `migrate up`", ErrSchemaBehind, version, latest)
+	case version > latest:
+		return fmt.Errorf("%w: version %d, this build knows up to %d", ErrSchemaAhead, version, latest)
+	}
+
+	logger.WithCtx(ctx).Infof("Schema version %d", version)
+	return nil
+}
+
+// Migrate runs the migrate subcommand against the primary database only:
+//
+//	migrate up [N] [--dry-run]       apply all pending migrations, or the next N
+//	migrate down [N] [--yes]         roll back the last N migrations, 1 by default
+//	migrate status                   list every migration as applied or pending
+//	migrate force V                  mark version V as applied and clean after fixing a failed migration by hand
+//
+// Down migrations drop tables and columns, so without --yes down only prints what it would roll back.
+func Migrate(ctx context.Context, config Config, rootPath string, args []string) error {
+	var dryRun, confirmed bool
+	var positional []string
+	for _, arg := range args {
+		switch arg {
+		case "--dry-run":
+			dryRun = true
+		case "--yes":
+			confirmed = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	args = positional
+
+	if len(args) == 0 {
+		return errors.New("usage: migrate up [N] [--dry-run] | down [N] [--yes] | status | force VERSION")
+	}
+
+	migrations, err := listMigrations(rootPath)
+	if err != nil {
+		return err
+	}
+
+	db := connections.DbInstance(config.Database)
+	defer db.Close()
+
+	m, err := newMigrator(db, rootPath)
+	if err != nil {
+		return err
+	}
+	current, dirty, err := schemaVersion(m)
+	if err != nil {
+		return err
+	}
+
+	steps := func(fallback int) (int, error) {
+		if len(args) < 2 {
+			return fallback, nil
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of migrations %q", args[1])
+		}
+		return n, nil
+	}
+
+	log := logger.WithCtx(ctx)
+
+	switch args[0] {
+	case "up":
+		n, err := steps(0)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			var pending []migration
+			for _, mig := range migrations {
+				if mig.version > current {
+					pending = append(pending, mig)
+				}
+			}
+			if n > 0 && n < len(pending) {
+				pending = pending[:n]
+			}
+			if len(pending) == 0 {
+				log.Info("Dry run: no pending migrations")
+			}
+			for _, mig := range pending {
+				log.Infof("Dry run: would apply %06d_%s", mig.version, mig.name)
+			}
+			break
+		}
+		if n == 0 {
+			err = m.Up()
+		} else {
+			err = m.Steps(n)
+		}
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migrate up failed: %w", err)
+		}
+
+	case "down":
+		n, err := steps(1)
+		if err != nil {
+			return err
+		}
+
+		var applied []migration
+		for i := len(migrations) - 1; i >= 0 && len(applied) < n; i-- {
+			if migrations[i].version <= current {
+				applied = append(applied, migrations[i])
+			}
+		}
+		for _, mig := range applied {
+			if !mig.down {
+				return fmt.Errorf("migration %06d_%s has no down file", mig.version, mig.name)
+			}
+			log.Warnf("Would roll back %06d_%s", mig.version, mig.name)
+		}
+		if len(applied) == 0 {
+			log.Info("No migrations to roll back")
+			break
+		}
+		if dryRun || !confirmed {
+			log.Warn("Rolling back drops the tables and columns above with their data; run again with --yes to do it")
+			break
+		}
+
+		if err := m.Steps(-len(applied)); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migrate down failed: %w", err)
+		}
+
+	case "status":
+		for _, mig := range migrations {
+			state := "pending"
+			if mig.version <= current {
+				state = "applied"
+			}
+			if mig.version == current && dirty {
+				state = "dirty"
+			}
+			switch {
+			case !mig.up:
+				state += ", missing up file"
+			case !mig.down:
+				state += ", missing down file"
+			}
+			log.Infof("%06d_%s: %s", mig.version, mig.name, state)
+		}
+
+	case "force":
+		if len(args) < 2 {
+			return errors.New("usage: migrate force VERSION")
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := m.Force(version); err != nil {
+			return fmt.Errorf("migrate force failed: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown migrate command %q, want up, down, status or force", args[0])
+	}
+
+	version, dirty, err := schemaVersion(m)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		log.Info("Schema version: none, no migrations applied")
+		return nil
+	}
+	log.Infof("Schema version: %d, dirty: %v", version, dirty)
+	return nil
+}
+
+// Seed loads the development data in seeds/*.sql, in file name order. Seed files
+// must be safe to run again, using INSERT IGNORE or ON DUPLICATE KEY UPDATE.
+func Seed(ctx context.Context, config Config, rootPath string) error {
+	files, err := filepath.Glob(filepath.Join(rootPath, "seeds", "*.sql"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	db := connections.DbInstance(config.Database)
+	defer db.Close()
+
+	for _, file := range files {
+		statements, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read seed file: %w", err)
+		}
+
+		// The connection allows multiple statements per Exec
+		if _, err := db.ExecContext(ctx, string(statements)); err != nil {
+			return fmt.Errorf("seed %s failed: %w", filepath.Base(file), err)
+		}
+		logger.WithCtx(ctx).Infof("✅ Seeded %s", filepath.Base(file))
+	}
+	return nil
+}
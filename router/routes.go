@@ -1,16 +1,18 @@
 package router
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/go-redis/redis"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/sirupsen/logrus"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"github.com/uptrace/opentelemetry-go-extra/otellogrus"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"ussd-wrapper/library/logger"
 
@@ -18,68 +20,147 @@ import (
 	"os"
 	"time"
 	"ussd-wrapper/connections"
+	"ussd-wrapper/constants"
 	"ussd-wrapper/controller"
+	"ussd-wrapper/inbox"
 	"ussd-wrapper/library"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/outbox"
+	"ussd-wrapper/payments"
 	"ussd-wrapper/queue"
+	"ussd-wrapper/settings"
+	"ussd-wrapper/wallet"
 )
 
-func Init(rootPath string) error {
+// Mode selects what a process runs
+type Mode struct {
+	Migrate bool // apply pending migrations before starting
+	HTTP    bool // serve the API; without it only /health is served, on worker_port
+	Workers bool // consume queues and run the outbox relay, reconciler and sweeps
+}
+
+var (
+	// ModeAll runs everything in one process, as before run modes existed
+	ModeAll = Mode{Migrate: true, HTTP: true, Workers: true}
+	// ModeServe runs the USSD front end and API only
+	ModeServe = Mode{HTTP: true}
+	// ModeWorker runs the background workers only
+	ModeWorker = Mode{Workers: true}
+)
+
+// Init starts the parts of the service selected by mode and runs them until ctx
+// is cancelled, then shuts them down gracefully
+func Init(ctx context.Context, config Config, rootPath string, mode Mode) error {
 	// 🟣 1. Tracer Setup
-	ctx, err := library.InitTracer()
-	if err != nil {
+	if err := library.InitTracer(ctx, config.Tracing); err != nil {
 		return err
 	}
 	tracer := library.SetupTracer()
 
 	// Create a root span (a trace) to measure some operation.
+	// It is ended by shutdown, before the tracer is flushed.
 	ctx, main := tracer.Start(ctx, "ussd-wrapper")
-	// End the span when the operation we are measuring is done.
-	defer main.End()
 
 	// 🟡 2. Database Connections and Migrations
-	dbInstance := connections.DbInstance()
-	dbSlave := connections.DbInstanceSlave()
-
-	driver, err := mysql.WithInstance(dbInstance, &mysql.Config{})
-	if err != nil {
+	dbInstance := connections.DbInstance(config.Database)
+	dbSlave := connections.DbInstanceSlave(config.Database)
 
-		logrus.Panic(err)
+	if mode.Migrate {
+		if err := migrateUp(ctx, dbInstance, rootPath); err != nil {
+			return err
+		}
+	}
+	if err := checkSchema(ctx, dbInstance, rootPath); err != nil {
+		return err
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file:///%s/migrations", rootPath), "mysql", driver)
-	if err != nil {
+	// 🟢 3. Redis
+	redisClient := connections.InitRedis(ctx, config.Redis)
 
-		logrus.Errorf("migration setup error %s ", err.Error())
+	// 🔵 4. RabbitMQ Connection
+	rabbitConn, err := connections.InitializeClient(ctx, config.RabbitMQ)
+	if err != nil {
+		return fmt.Errorf("failed to initialize RabbitMQ: %w", err)
 	}
 
-	err = m.Up() // or m.Step(2) if you want to explicitly set the number of migrations to run
+	// Exchanges, queues and bindings from the topology file
+	topologyFile := config.TopologyFile
+	if topologyFile == "" {
+		topologyFile = rootPath + "/topology.yml"
+	}
+	topology, err := connections.LoadTopology(topologyFile)
 	if err != nil {
+		return err
+	}
+	if err := rabbitConn.UseTopology(topology); err != nil {
+		return fmt.Errorf("failed to declare RabbitMQ topology: %w", err)
+	}
 
-		logrus.Errorf("migration error %s ", err.Error())
+	// SMS providers, routing rules and worker for the outbound SMS queue
+	smsRouter, err := notifications.NewRouter(config.Notifications, dbInstance)
+	if err != nil {
+		return err
 	}
+	smsPublisher := notifications.NewPublisher(config.Notifications, dbInstance, tracer)
+	smsWorker := notifications.NewWorker(config.Notifications, dbInstance, redisClient, tracer, smsPublisher, smsRouter)
 
-	// 🟢 3. Redis
-	redisClient := connections.InitRedis(ctx)
+	// Notification templates and the worker rendering queued receipts
+	templates := notifications.NewTemplates(config.Notifications, dbInstance, tracer, config.Wallet.Currency)
+	templateWorker := notifications.NewTemplateWorker(dbInstance, tracer, templates, smsPublisher)
 
-	// 🔵 4. RabbitMQ Connection
-	rabbitConn, rmqErr := connections.InitializeClient(ctx)
-	if rmqErr != nil {
-		log.Fatalf("Failed to initialize RabbitMQ: %v", err)
+	// 💳 5. Wallet service and mobile money providers
+	provider, err := payments.NewProvider(config.Payments, config.Controller.PaymentCallbackToken)
+	if err != nil {
+		return err
 	}
-
-	// Create the queue manager
-	queueManager, qMerr := queue.NewQueueManager(tracer, dbInstance, dbSlave, redisClient)
-	if qMerr != nil {
-		log.Fatalf("Failed to create queue manager: %v", qMerr)
+	payoutProvider, err := payments.NewPayoutProvider(config.Payments, config.Controller.PaymentCallbackToken)
+	if err != nil {
+		return err
 	}
+	notifier := notifications.NewOutboxNotifier(config.Notifications, tracer)
+	walletService := wallet.NewService(config.Wallet, dbInstance, dbSlave, tracer, provider, payoutProvider, notifier)
 
-	// Start all consumers
-	go queueManager.InitializeQueues(ctx)
+	// ⚙️ Runtime settings from system_config and the config file, kept in sync
+	// across instances through Redis
+	settingsStore, err := settings.NewStore(ctx, dbInstance, redisClient, runtimeSettings(config))
+	if err != nil {
+		return err
+	}
+	settingsStore.Subscribe(func(_, value string) {
+		level, _ := logrus.ParseLevel(value)
+		logger.SetLevel(level)
+	}, settings.KeyLogLevel)
+	watchConfigFile(ctx, settingsStore)
+
+	// Background workers run until shutdown, after HTTP has stopped
+	workers := newWorkerGroup(ctx)
+	workers.Go(settingsStore.Watch)
+	if mode.Workers {
+		if err := startWorkers(workers, config, dbInstance, dbSlave, redisClient, rabbitConn, tracer, walletService, smsPublisher, smsWorker, templateWorker); err != nil {
+			return err
+		}
+	}
 
-	// 🔗 5. Create Controller with dependencies
-	ctrl := controller.NewController(dbInstance, dbSlave, redisClient, rabbitConn, tracer)
+	// 🔗 6. Create Controller with dependencies
+	ctrl := controller.NewController(config.Controller, dbInstance, dbSlave, redisClient, rabbitConn, tracer, walletService, smsWorker, templates, settingsStore)
+
+	if !mode.HTTP {
+		// Workers only answer the health check, for liveness probes
+		e := echo.New()
+		e.HideBanner = true
+		e.GET("/health", ctrl.HealthCheck)
+		return run(ctx, e, fmt.Sprintf(":%d", config.WorkerPort), service{
+			timeout: time.Duration(config.ShutdownTimeout) * time.Second,
+			echo:    e,
+			workers: workers,
+			span:    main,
+			redis:   redisClient,
+			db:      dbInstance,
+			dbSlave: dbSlave,
+		})
+	}
 
-	// 🚀 6. Echo Setup
+	// 🚀 7. Echo Setup
 	e := echo.New()
 	e.Static("/doc", "api")
 	e.Use(middleware.Gzip())
@@ -162,18 +243,83 @@ func Init(rootPath string) error {
 	}
 
 	e.Use(middleware.CORSWithConfig(corsConfig))
-	// 📘 7. Swagger UI
+	// 📘 8. Swagger UI
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
-	// 🧩 8. Register Routes
+	// 🧩 9. Register Routes
 	registerRoutes(e, ctrl)
 
-	// 🔄 9. Start Echo server
-	log.Println("✅ USSD Wrapper Server running on :8080")
+	// 🔄 10. Start Echo server
+	address := fmt.Sprintf(":%d", config.Port)
+	log.Println("✅ USSD Wrapper Server running on " + address)
+
+	return run(ctx, e, address, service{
+		timeout: time.Duration(config.ShutdownTimeout) * time.Second,
+		echo:    e,
+		workers: workers,
+		span:    main,
+		redis:   redisClient,
+		db:      dbInstance,
+		dbSlave: dbSlave,
+	})
+}
+
+// run serves HTTP on address until ctx is cancelled or the server fails, then shuts s down
+func run(ctx context.Context, e *echo.Echo, address string, s service) error {
+	logger.WithCtx(ctx).Infof("App started, listening on %s", address)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- e.Start(address)
+	}()
+
+	var startErr error
+	select {
+	case <-ctx.Done():
+		logger.WithCtx(ctx).Info("Shutdown signal received")
+	case startErr = <-serverErr:
+		logger.WithCtx(ctx).Errorf("HTTP server stopped: %v", startErr)
+	}
+
+	return errors.Join(startErr, shutdown(ctx, s))
+}
 
-	logger.WithCtx(ctx).Info("App started")
+// startWorkers starts the outbox relay, the reconciler, the sweeps and a consumer for every queue of the topology
+func startWorkers(workers *workerGroup, config Config, db, dbSlave *sql.DB, redisClient *redis.Client, rabbitConn *connections.RabbitMQClient,
+	tracer trace.Tracer, walletService *wallet.Service, smsPublisher *notifications.Publisher,
+	smsWorker *notifications.Worker, templateWorker *notifications.TemplateWorker) error {
+	// Release the holds of withdrawal codes that were never redeemed
+	workers.Go(walletService.WatchWithdrawalCodes)
+
+	// Publish outbox events written by committed transactions
+	relay, err := outbox.NewRelay(config.Outbox, db, rabbitConn, tracer)
+	if err != nil {
+		return err
+	}
+	workers.Go(relay.Run)
+
+	// Resolve deposits and payouts whose provider callback never arrives
+	reconciler := queue.NewReconciler(config.Reconciler, tracer, walletService, smsPublisher)
+	workers.Go(reconciler.Run)
+
+	// Consumers of the domain's queues, one handler per channel
+	handlers := queue.NewRegistry()
+	handlers.Register(constants.SMSOutboundChannel, queue.JSONHandler[notifications.SMSJob](smsWorker.Handle))
+	handlers.Register(constants.NotificationsChannel, queue.JSONHandler[notifications.Notification](templateWorker.Handle))
+	handlers.Register(constants.ReconcileChannel, queue.JSONHandler[queue.ReconcileRequest](reconciler.HandleRequest))
+
+	// Create the queue manager
+	queueManager, err := queue.NewQueueManager(config.Queues, tracer, db, dbSlave, redisClient, rabbitConn, handlers)
+	if err != nil {
+		return fmt.Errorf("failed to create queue manager: %w", err)
+	}
+
+	// Start all consumers
+	workers.Go(queueManager.InitializeQueues)
 
-	return e.Start(":8080")
+	// Forget processed message IDs once no redelivery can arrive
+	workers.Go(func(ctx context.Context) { inbox.Sweep(ctx, db, config.Queues.RetentionDays) })
+	return nil
 }
 
 // Now we'll use the controller's RegisterRoutes method
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"ussd-wrapper/connections"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+
+	"github.com/go-redis/redis"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// workerGroup runs the background workers. Their context is detached from the signal
+// context so they keep running while HTTP requests finish, and is cancelled by stop.
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorkerGroup(ctx context.Context) *workerGroup {
+	workerCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	return &workerGroup{ctx: workerCtx, cancel: cancel}
+}
+
+// Go starts run, which must return once its context is cancelled
+func (w *workerGroup) Go(run func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		run(w.ctx)
+	}()
+}
+
+// stop cancels the workers and waits for them until deadline is done
+func (w *workerGroup) stop(deadline context.Context) error {
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-deadline.Done():
+		return fmt.Errorf("workers still running: %w", deadline.Err())
+	}
+}
+
+// service is what shutdown stops, in this order, within timeout
+type service struct {
+	timeout time.Duration
+	echo    *echo.Echo
+	workers *workerGroup
+	span    trace.Span
+	redis   *redis.Client
+	db      *sql.DB
+	dbSlave *sql.DB
+}
+
+// shutdown stops the service within s.timeout: HTTP stops accepting and
+// in-flight requests finish, consumers and workers drain, spans are flushed, and then
+// RabbitMQ, Redis and the databases are closed. Every step runs even if one fails.
+func shutdown(ctx context.Context, s service) error {
+	deadline, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+	defer cancel()
+
+	log := logger.WithCtx(ctx)
+	log.Infof("Shutting down, deadline %s", s.timeout)
+
+	var errs []error
+	step := func(name string, err error) {
+		if err != nil {
+			log.Errorf("Shutdown: %s failed: %v", name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		log.Infof("Shutdown: %s done", name)
+	}
+
+	step("http server", s.echo.Shutdown(deadline))
+	step("consumers and workers", s.workers.stop(deadline))
+
+	s.span.End()
+	step("trace export", library.ShutdownTracer(deadline))
+
+	step("rabbitmq", connections.CloseClient())
+	step("redis", s.redis.Close())
+	step("database replica", s.dbSlave.Close())
+	step("database", s.db.Close())
+
+	return errors.Join(errs...)
+}
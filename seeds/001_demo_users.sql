@@ -0,0 +1,10 @@
+-- ====================
+-- Development seed data
+-- ====================
+-- Loaded by `main seed`; safe to run again
+INSERT IGNORE INTO users (phone_number, pin, first_name, last_name, balance)
+VALUES ('254700000001', 'hashed_pin_example', 'Jane', 'Doe', 500.00),
+       ('254700000002', 'hashed_pin_example', 'John', 'Doe', 500.00);
+
+INSERT IGNORE INTO system_config (setting_key, value, description)
+VALUES ('maintenance_mode', 'off', 'Enable or disable system-wide maintenance mode');
@@ -0,0 +1,343 @@
+package settings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// Keys of the settings that can be changed at runtime
+const (
+	KeyMaintenanceMode = "maintenance_mode"
+	KeyLogLevel        = "log_level"
+)
+
+// Sources of a setting's current value
+const (
+	SourceConfig   = "config"   // config file or environment
+	SourceDatabase = "database" // system_config, which wins over the config
+)
+
+var (
+	// ErrUnknownSetting is returned for keys that cannot be changed at runtime
+	ErrUnknownSetting = errors.New("unknown setting")
+
+	// ErrInvalidValue is returned for values a setting does not accept
+	ErrInvalidValue = errors.New("invalid setting value")
+)
+
+type definition struct {
+	description string
+	validate    func(value string) error
+}
+
+var definitions = map[string]definition{
+	KeyMaintenanceMode: {"Reject USSD sessions and partner requests with a maintenance message (on or off)", validateBool},
+	KeyLogLevel:        {"Lowest level that is logged (debug, info, warn, error)", validateLogLevel},
+}
+
+// Setting is the current value of a runtime setting
+type Setting struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Source      string `json:"source"`
+	Description string `json:"description"`
+}
+
+// Store holds the runtime settings: the config file and environment values, overridden
+// by the rows of system_config. Changes made through Set are announced to the other
+// instances on InvalidationChannel; subscribers are called with every new value.
+type Store struct {
+	db    *sql.DB
+	redis *redis.Client
+
+	mu          sync.RWMutex
+	config      map[string]string
+	overrides   map[string]string
+	subscribers map[string][]func(key, value string)
+}
+
+// NewStore creates the store from the config values of the runtime settings and
+// loads the overrides in system_config
+func NewStore(ctx context.Context, db *sql.DB, redisClient *redis.Client, config map[string]string) (*Store, error) {
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		db:          db,
+		redis:       redisClient,
+		config:      config,
+		overrides:   make(map[string]string),
+		subscribers: make(map[string][]func(key, value string)),
+	}
+	if err := s.reload(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Subscribe calls fn with the current value of each of keys, and again whenever one changes
+func (s *Store) Subscribe(fn func(key, value string), keys ...string) {
+	s.mu.Lock()
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		s.subscribers[key] = append(s.subscribers[key], fn)
+		values[key] = s.value(key)
+	}
+	s.mu.Unlock()
+
+	for _, key := range keys {
+		fn(key, values[key])
+	}
+}
+
+// String returns the current value of key
+func (s *Store) String(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value(key)
+}
+
+// Bool returns the current value of key as a bool, false when it is not one
+func (s *Store) Bool(key string) bool {
+	on, _ := parseBool(s.String(key))
+	return on
+}
+
+// List returns every runtime setting, sorted by key
+func (s *Store) List() []Setting {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Setting, 0, len(definitions))
+	for key, def := range definitions {
+		setting := Setting{Key: key, Value: s.value(key), Source: SourceConfig, Description: def.description}
+		if _, ok := s.overrides[key]; ok {
+			setting.Source = SourceDatabase
+		}
+		list = append(list, setting)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// Set stores value for key in system_config on behalf of updatedBy, records the
+// change in audit_logs and tells the other instances to reload it
+func (s *Store) Set(ctx context.Context, key, value, updatedBy string) (Setting, error) {
+	def, ok := definitions[key]
+	if !ok {
+		return Setting{}, fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	value = strings.TrimSpace(value)
+	if err := def.validate(value); err != nil {
+		return Setting{}, fmt.Errorf("%s: %w", key, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Setting{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT value FROM system_config WHERE setting_key = ? FOR UPDATE", key).Scan(&old)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Setting{}, fmt.Errorf("failed to load setting %s: %w", key, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO system_config (setting_key, value, description, updated_by) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE value = VALUES(value), updated_by = VALUES(updated_by)",
+		key, value, def.description, updatedBy)
+	if err != nil {
+		return Setting{}, fmt.Errorf("failed to store setting %s: %w", key, err)
+	}
+
+	err = library.RecordAudit(ctx, tx, models.AuditLog{
+		Action:     "setting_updated",
+		EntityType: "system_config",
+		EntityID:   key,
+		OldValue:   models.JSONMap{"value": old.String},
+		NewValue:   models.JSONMap{"value": value, "updated_by": updatedBy},
+	})
+	if err != nil {
+		return Setting{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Setting{}, fmt.Errorf("failed to commit setting %s: %w", key, err)
+	}
+
+	s.update(ctx, func() { s.overrides[key] = value })
+	s.announce(ctx, key)
+
+	return Setting{Key: key, Value: value, Source: SourceDatabase, Description: def.description}, nil
+}
+
+// SetConfigValues replaces the config values after the config file changed. The
+// change is audited with source as updated_by; invalid values are rejected as a whole.
+func (s *Store) SetConfigValues(ctx context.Context, values map[string]string, source string) error {
+	s.mu.RLock()
+	candidate := make(map[string]string, len(s.config))
+	for key, value := range s.config {
+		candidate[key] = value
+	}
+	s.mu.RUnlock()
+
+	changed := make(map[string]string)
+	for key, value := range values {
+		if _, ok := definitions[key]; !ok || candidate[key] == value {
+			continue
+		}
+		changed[key] = candidate[key]
+		candidate[key] = value
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := Validate(candidate); err != nil {
+		return err
+	}
+
+	for key, old := range changed {
+		err := library.RecordAudit(ctx, s.db, models.AuditLog{
+			Action:     "setting_updated",
+			EntityType: "config",
+			EntityID:   key,
+			OldValue:   models.JSONMap{"value": old},
+			NewValue:   models.JSONMap{"value": candidate[key], "updated_by": source},
+		})
+		if err != nil {
+			// The file has changed either way; the value is applied without the entry
+			logger.WithCtx(ctx).Errorf("failed to audit change of %s: %v", key, err)
+		}
+	}
+
+	s.update(ctx, func() { s.config = candidate })
+	return nil
+}
+
+// reload reads every override from system_config. Rows with invalid values are
+// skipped so a bad manual edit cannot take the service down.
+func (s *Store) reload(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT setting_key, value FROM system_config WHERE value IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return fmt.Errorf("failed to scan setting: %w", err)
+		}
+		def, ok := definitions[key]
+		if !ok {
+			continue
+		}
+		if err := def.validate(value); err != nil {
+			logger.WithCtx(ctx).Errorf("ignoring system_config %s: %v", key, err)
+			continue
+		}
+		overrides[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	s.update(ctx, func() { s.overrides = overrides })
+	return nil
+}
+
+// update applies mutate and calls the subscribers of every key whose value changed
+func (s *Store) update(ctx context.Context, mutate func()) {
+	s.mu.Lock()
+	before := s.snapshot()
+	mutate()
+	after := s.snapshot()
+
+	var notify []func()
+	for key, value := range after {
+		if before[key] == value {
+			continue
+		}
+		logger.WithCtx(ctx).Infof("Setting %s changed from %q to %q", key, before[key], value)
+		for _, fn := range s.subscribers[key] {
+			fn, key, value := fn, key, value
+			notify = append(notify, func() { fn(key, value) })
+		}
+	}
+	s.mu.Unlock()
+
+	for _, fn := range notify {
+		fn()
+	}
+}
+
+// snapshot returns the current value of every setting; s.mu must be held
+func (s *Store) snapshot() map[string]string {
+	values := make(map[string]string, len(definitions))
+	for key := range definitions {
+		values[key] = s.value(key)
+	}
+	return values
+}
+
+// value returns the current value of key; s.mu must be held
+func (s *Store) value(key string) string {
+	if value, ok := s.overrides[key]; ok {
+		return value
+	}
+	return s.config[key]
+}
+
+// Validate checks the values of runtime settings; unknown keys are ignored
+func Validate(values map[string]string) error {
+	for key, value := range values {
+		def, ok := definitions[key]
+		if !ok {
+			continue
+		}
+		if err := def.validate(value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := parseBool(value); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateLogLevel(value string) error {
+	if _, err := logrus.ParseLevel(value); err != nil {
+		return fmt.Errorf("%w: %q is not a log level", ErrInvalidValue, value)
+	}
+	return nil
+}
+
+// parseBool accepts on/off as stored for maintenance_mode, as well as true/false
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "on", "true", "1", "yes":
+		return true, nil
+	case "off", "false", "0", "no", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: %q is not on or off", ErrInvalidValue, value)
+}
@@ -0,0 +1,94 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redis"
+)
+
+var testConfig = map[string]string{
+	KeyMaintenanceMode: "off",
+	KeyLogLevel:        "info",
+}
+
+func TestValidate(t *testing.T) {
+	valid := []map[string]string{
+		testConfig,
+		{KeyMaintenanceMode: "on"},
+		{"currency": "KES"},
+	}
+	for _, values := range valid {
+		if err := Validate(values); err != nil {
+			t.Errorf("Validate(%v) = %v", values, err)
+		}
+	}
+
+	invalid := []map[string]string{
+		{KeyMaintenanceMode: "maybe"},
+		{KeyLogLevel: "verbose"},
+	}
+	for _, values := range invalid {
+		if err := Validate(values); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("Validate(%v) = %v, want %v", values, err, ErrInvalidValue)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr error
+	}{
+		{"valid change", KeyLogLevel, "debug", nil},
+		{"invalid value", KeyLogLevel, "verbose", ErrInvalidValue},
+		{"unknown setting", "currency", "USD", ErrUnknownSetting},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			// Announcements fail fast and are only logged
+			redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 10 * time.Millisecond, MaxRetries: -1})
+			defer redisClient.Close()
+
+			s := &Store{
+				db:          db,
+				redis:       redisClient,
+				config:      testConfig,
+				overrides:   make(map[string]string),
+				subscribers: make(map[string][]func(key, value string)),
+			}
+
+			if tt.wantErr == nil {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT value FROM system_config WHERE setting_key = \\? FOR UPDATE").WithArgs(tt.key).
+					WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow("info"))
+				mock.ExpectExec("INSERT INTO system_config").WithArgs(tt.key, tt.value, sqlmock.AnyArg(), "ops").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			}
+
+			_, err = s.Set(context.Background(), tt.key, tt.value, "ops")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Set = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && s.String(tt.key) != tt.value {
+				t.Errorf("%s is %q, want %q", tt.key, s.String(tt.key), tt.value)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+	"ussd-wrapper/library/logger"
+
+	"github.com/go-redis/redis"
+)
+
+// InvalidationChannel is the Redis pub/sub channel a changed setting's key is published on
+const InvalidationChannel = "settings:invalidate"
+
+// refreshInterval is how often Watch reloads everything, covering announcements
+// missed while an instance was disconnected from Redis
+const refreshInterval = time.Minute
+
+// announce tells the other instances to reload key. A failed publish is only
+// logged: they pick the change up on their next refresh.
+func (s *Store) announce(ctx context.Context, key string) {
+	if err := s.redis.Publish(InvalidationChannel, key).Err(); err != nil {
+		logger.WithCtx(ctx).Warnf("failed to announce change of setting %s: %v", key, err)
+	}
+}
+
+// Watch reloads the settings whenever another instance announces a change, after
+// every (re)subscription and every refreshInterval, until ctx is cancelled
+func (s *Store) Watch(ctx context.Context) {
+	pubsub := s.redis.Subscribe(InvalidationChannel)
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
+	failures := 0
+	for {
+		msg, err := pubsub.ReceiveTimeout(refreshInterval)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			// Quiet channel, refresh anyway
+		case err != nil:
+			failures++
+			logger.WithCtx(ctx).Warnf("settings subscription failed: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(min(failures, 30)) * time.Second):
+			}
+			continue
+		default:
+			failures = 0
+			switch m := msg.(type) {
+			case *redis.Subscription:
+				// Subscribed again after a reconnect; changes may have been missed
+			case *redis.Message:
+				logger.WithCtx(ctx).Infof("Setting %s changed on another instance", m.Payload)
+			default:
+				continue
+			}
+		}
+
+		if err := s.reload(ctx); err != nil {
+			logger.WithCtx(ctx).Errorf("failed to reload settings: %v", err)
+		}
+	}
+}
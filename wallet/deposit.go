@@ -0,0 +1,180 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"ussd-wrapper/inbox"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/payments"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InitiateDeposit records a pending deposit and asks the mobile money provider
+// to prompt the customer's handset for payment. The deposit is completed or
+// failed later by the provider callback or the reconciliation worker; only a
+// request the provider rejects fails straight away.
+func (s *Service) InitiateDeposit(ctx context.Context, phoneNumber string, amount float64) (*models.Transaction, error) {
+	ctx, span := s.tracer.Start(ctx, "InitiateDeposit",
+		trace.WithAttributes(attribute.Float64("amount", amount)))
+	defer span.End()
+
+	user, err := s.GetUserByPhone(ctx, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := library.GenerateTransactionID("DP")
+	metadata := models.JSONMap{
+		"channel":  "ussd",
+		"method":   "mobile_money",
+		"provider": s.provider.Name(),
+		"msisdn":   phoneNumber,
+		"currency": s.config.Currency,
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO transactions (reference_id, transaction_type, recipient_id, amount, status, description, metadata) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		reference, models.TransactionTypeDeposit, user.ID, amount, models.TransactionStatusPending, "Mobile money deposit", metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record deposit %s: %w", reference, err)
+	}
+
+	res, err := s.provider.InitiateDeposit(ctx, payments.DepositRequest{
+		Reference:   reference,
+		MSISDN:      phoneNumber,
+		Amount:      amount,
+		Currency:    s.config.Currency,
+		Description: "Wallet deposit",
+		CallbackURL: s.config.PaymentCallbackURL,
+	})
+	if err == nil && res.Status == payments.StatusFailed {
+		err = fmt.Errorf("%w: %s", payments.ErrRejected, res.Message)
+	}
+	if errors.Is(err, payments.ErrRejected) {
+		// The provider declined the request, so no prompt reached the customer
+		if failErr := s.ApplyDepositResult(ctx, payments.Result{
+			Reference:  reference,
+			Status:     payments.StatusFailed,
+			ResultDesc: err.Error(),
+		}); failErr != nil {
+			logger.WithCtx(ctx).Errorf("failed to mark deposit %s as failed: %v", reference, failErr)
+		}
+		return nil, fmt.Errorf("failed to initiate deposit %s: %w", reference, err)
+	}
+	if err != nil {
+		// The prompt may still have been sent, so the deposit stays pending
+		// until the callback or the reconciler settles it
+		logger.WithCtx(ctx).Warnf("outcome of deposit %s unknown, leaving it to reconciliation: %v", reference, err)
+	} else {
+		metadata["provider_reference"] = res.ProviderReference
+		_, err = s.db.ExecContext(ctx, "UPDATE transactions SET metadata = ? WHERE reference_id = ?", metadata, reference)
+		if err != nil {
+			logger.WithCtx(ctx).Errorf("failed to store provider reference for deposit %s: %v", reference, err)
+		}
+	}
+
+	return &models.Transaction{
+		ReferenceID: reference,
+		Type:        models.TransactionTypeDeposit,
+		RecipientID: &user.ID,
+		Amount:      amount,
+		Status:      models.TransactionStatusPending,
+		Metadata:    metadata,
+	}, nil
+}
+
+// HandleDepositCallback parses a provider callback and applies it to the pending deposit
+func (s *Service) HandleDepositCallback(ctx context.Context, body []byte) (*payments.Result, error) {
+	result, err := s.provider.ParseDepositCallback(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, s.ApplyDepositResult(ctx, *result)
+}
+
+// ApplyDepositResult completes or fails a pending deposit. Results for deposits
+// that are no longer pending are ignored so callbacks and status queries can race safely.
+func (s *Service) ApplyDepositResult(ctx context.Context, result payments.Result) error {
+	ctx, span := s.tracer.Start(ctx, "ApplyDepositResult",
+		trace.WithAttributes(attribute.String("reference", result.Reference), attribute.String("status", string(result.Status))))
+	defer span.End()
+
+	if !result.Status.IsFinal() {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	txn, err := lockTransaction(ctx, tx, result.Reference, models.TransactionTypeDeposit)
+	if err != nil {
+		return err
+	}
+
+	if txn.Status != models.TransactionStatusPending {
+		logger.WithCtx(ctx).Infof("deposit %s already %s, ignoring %s result", txn.ReferenceID, txn.Status, result.Status)
+		return nil
+	}
+
+	txn.Metadata["result_code"] = result.ResultCode
+	txn.Metadata["result_desc"] = result.ResultDesc
+	txn.Metadata["resolved_at"] = time.Now().Format(time.RFC3339)
+	if result.ProviderReference != "" {
+		txn.Metadata["provider_reference"] = result.ProviderReference
+	}
+
+	status := models.TransactionStatusFailed
+	if result.Status == payments.StatusCompleted {
+		if result.Amount > 0 && result.Amount != txn.Amount {
+			return fmt.Errorf("deposit %s amount mismatch: expected %.2f got %.2f", txn.ReferenceID, txn.Amount, result.Amount)
+		}
+		if txn.RecipientID == nil {
+			return fmt.Errorf("deposit %s has no recipient", txn.ReferenceID)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance + ? WHERE id = ?", txn.Amount, *txn.RecipientID)
+		if err != nil {
+			return fmt.Errorf("failed to credit deposit %s: %w", txn.ReferenceID, err)
+		}
+		status = models.TransactionStatusCompleted
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE transactions SET status = ?, metadata = ? WHERE id = ?", status, txn.Metadata, txn.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update deposit %s: %w", txn.ReferenceID, err)
+	}
+
+	if txn.RecipientID != nil {
+		template := notifications.TemplateDepositCompleted
+		if status == models.TransactionStatusFailed {
+			template = notifications.TemplateDepositFailed
+		}
+		err = s.notifyUser(ctx, tx, *txn.RecipientID, template, txn.ReferenceID, map[string]interface{}{"amount": txn.Amount})
+		if err != nil {
+			return err
+		}
+	}
+
+	// When applied by a consumer, a redelivery of the same message cannot credit twice
+	if err := inbox.Record(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deposit %s: %w", txn.ReferenceID, err)
+	}
+
+	logger.WithCtx(ctx).Infof("deposit %s %s", txn.ReferenceID, status)
+	return nil
+}
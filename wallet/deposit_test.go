@@ -0,0 +1,110 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"ussd-wrapper/inbox"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/payments"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestApplyDepositResult(t *testing.T) {
+	tests := []struct {
+		name          string
+		stored        models.TransactionStatus
+		status        payments.Status
+		claimed       bool // applied by a consumer of a deduplicated queue
+		wantErr       error
+		wantTemplates []string
+	}{
+		{"completed credits the wallet", models.TransactionStatusPending, payments.StatusCompleted, false, nil, []string{notifications.TemplateDepositCompleted}},
+		{"failed leaves the balance alone", models.TransactionStatusPending, payments.StatusFailed, false, nil, []string{notifications.TemplateDepositFailed}},
+		{"settled deposit is not credited again", models.TransactionStatusCompleted, payments.StatusCompleted, false, nil, nil},
+		{"redelivery rolls back the credit", models.TransactionStatusPending, payments.StatusCompleted, true, inbox.ErrDuplicate, []string{notifications.TemplateDepositCompleted}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, notifier := newTestService(t, Config{}, nil)
+			ctx := context.Background()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(lockQuery).WithArgs("DP1", models.TransactionTypeDeposit).
+				WillReturnRows(transactionRows(7, "DP1", models.TransactionTypeDeposit, nil, int64(3), tt.stored, "{}"))
+			if tt.stored == models.TransactionStatusPending {
+				if tt.status == payments.StatusCompleted {
+					mock.ExpectExec("UPDATE users SET balance = balance \\+ \\?").WithArgs(100.0, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectExec("UPDATE transactions SET status = \\?").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT phone_number FROM users").WillReturnRows(phoneRows())
+			}
+			if tt.claimed {
+				ctx = inbox.WithClaim(ctx, "reconcile", "msg-1")
+				mock.ExpectExec("INSERT INTO processed_messages").WillReturnError(&mysql.MySQLError{Number: 1062})
+			}
+			if tt.stored == models.TransactionStatusPending && tt.wantErr == nil {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := s.ApplyDepositResult(ctx, payments.Result{Reference: "DP1", Status: tt.status, Amount: 100})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ApplyDepositResult = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(notifier.templates, tt.wantTemplates) {
+				t.Errorf("notified %v, want %v", notifier.templates, tt.wantTemplates)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestInitiateDeposit(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider *fakeProvider
+		wantErr  error
+	}{
+		{"accepted request stays pending", &fakeProvider{deposit: &payments.DepositResponse{ProviderReference: "P1", Status: payments.StatusPending}}, nil},
+		{"unknown outcome stays pending", &fakeProvider{depositErr: context.DeadlineExceeded}, nil},
+		{"rejected request fails the deposit", &fakeProvider{depositErr: payments.ErrRejected}, payments.ErrRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{}, tt.provider)
+			mock.ExpectQuery("FROM users WHERE phone_number = \\?").WillReturnRows(userRows(0))
+			mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(7, 1))
+			switch {
+			case tt.provider.deposit != nil:
+				mock.ExpectExec("UPDATE transactions SET metadata = \\?").WillReturnResult(sqlmock.NewResult(0, 1))
+			case tt.wantErr != nil:
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WillReturnRows(transactionRows(7, "", models.TransactionTypeDeposit, nil, int64(3), models.TransactionStatusPending, "{}"))
+				mock.ExpectExec("UPDATE transactions SET status = \\?").WithArgs(models.TransactionStatusFailed, sqlmock.AnyArg(), int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT phone_number FROM users").WillReturnRows(phoneRows())
+				mock.ExpectCommit()
+			}
+
+			txn, err := s.InitiateDeposit(context.Background(), "254700000003", 100)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("InitiateDeposit = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && txn.Status != models.TransactionStatusPending {
+				t.Errorf("deposit is %s, want it pending", txn.Status)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"ussd-wrapper/inbox"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/payments"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PayoutSuspenseAccount holds wallet funds while a B2C payout is in flight
+const PayoutSuspenseAccount = "payout_suspense"
+
+// payoutMethod tags mobile money withdrawals in transaction metadata
+const payoutMethod = "mobile_money"
+
+// InitiatePayout debits the wallet into the payout suspense account and asks the
+// provider to send the funds to the customer's mobile money account. The result
+// arrives through the payout callback or the reconciliation worker; the funds
+// are returned straight away only when the provider rejects the request.
+func (s *Service) InitiatePayout(ctx context.Context, phoneNumber string, amount float64) (*models.Transaction, error) {
+	ctx, span := s.tracer.Start(ctx, "InitiatePayout",
+		trace.WithAttributes(attribute.Float64("amount", amount)))
+	defer span.End()
+
+	user, err := s.GetUserByPhone(ctx, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := library.GenerateTransactionID("PO")
+	metadata := models.JSONMap{
+		"channel":  "ussd",
+		"method":   payoutMethod,
+		"provider": s.payouts.Name(),
+		"msisdn":   phoneNumber,
+		"currency": s.config.Currency,
+		"suspense": PayoutSuspenseAccount,
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET balance = balance - ? WHERE id = ? AND balance >= ?", amount, user.ID, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to debit wallet for %s: %w", reference, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, ErrInsufficientFunds
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE system_accounts SET balance = balance + ? WHERE account_key = ?", amount, PayoutSuspenseAccount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to credit payout suspense for %s: %w", reference, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO transactions (reference_id, transaction_type, sender_id, amount, status, description, metadata) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		reference, models.TransactionTypeWithdrawal, user.ID, amount, models.TransactionStatusPending, "Mobile money withdrawal", metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record payout %s: %w", reference, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit payout %s: %w", reference, err)
+	}
+
+	resp, err := s.payouts.InitiatePayout(ctx, payments.PayoutRequest{
+		Reference:   reference,
+		MSISDN:      phoneNumber,
+		Amount:      amount,
+		Currency:    s.config.Currency,
+		Remarks:     "Wallet withdrawal",
+		CallbackURL: s.config.PayoutCallbackURL,
+	})
+	if err == nil && resp.Status == payments.StatusFailed {
+		err = fmt.Errorf("%w: %s", payments.ErrRejected, resp.Message)
+	}
+	if errors.Is(err, payments.ErrRejected) {
+		// The provider will never send the funds, so they can go straight back
+		if failErr := s.ApplyPayoutResult(ctx, payments.Result{
+			Reference:  reference,
+			Status:     payments.StatusFailed,
+			ResultDesc: err.Error(),
+		}); failErr != nil {
+			logger.WithCtx(ctx).Errorf("failed to reverse payout %s: %v", reference, failErr)
+		}
+		return nil, fmt.Errorf("failed to initiate payout %s: %w", reference, err)
+	}
+	if err != nil {
+		// The provider may have sent the funds, so refunding now could pay out twice.
+		// The payout stays pending until the callback or the reconciler settles it.
+		logger.WithCtx(ctx).Warnf("outcome of payout %s unknown, leaving it to reconciliation: %v", reference, err)
+	} else {
+		metadata["provider_reference"] = resp.ProviderReference
+		_, err = s.db.ExecContext(ctx, "UPDATE transactions SET metadata = ? WHERE reference_id = ?", metadata, reference)
+		if err != nil {
+			logger.WithCtx(ctx).Errorf("failed to store provider reference for payout %s: %v", reference, err)
+		}
+	}
+
+	return &models.Transaction{
+		ReferenceID: reference,
+		Type:        models.TransactionTypeWithdrawal,
+		SenderID:    &user.ID,
+		Amount:      amount,
+		Status:      models.TransactionStatusPending,
+		Metadata:    metadata,
+	}, nil
+}
+
+// HandlePayoutCallback parses a provider result callback and applies it to the pending payout
+func (s *Service) HandlePayoutCallback(ctx context.Context, body []byte) (*payments.Result, error) {
+	result, err := s.payouts.ParsePayoutCallback(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, s.ApplyPayoutResult(ctx, *result)
+}
+
+// ApplyPayoutResult settles a pending payout out of the suspense account, or
+// reverses the funds back to the wallet when the provider reports a failure
+func (s *Service) ApplyPayoutResult(ctx context.Context, result payments.Result) error {
+	ctx, span := s.tracer.Start(ctx, "ApplyPayoutResult",
+		trace.WithAttributes(attribute.String("reference", result.Reference), attribute.String("status", string(result.Status))))
+	defer span.End()
+
+	if !result.Status.IsFinal() {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	txn, err := lockTransaction(ctx, tx, result.Reference, models.TransactionTypeWithdrawal)
+	if err != nil {
+		return err
+	}
+	if txn.Metadata["method"] != payoutMethod {
+		return ErrTransactionNotFound
+	}
+
+	if txn.Status != models.TransactionStatusPending {
+		logger.WithCtx(ctx).Infof("payout %s already %s, ignoring %s result", txn.ReferenceID, txn.Status, result.Status)
+		return nil
+	}
+
+	txn.Metadata["result_code"] = result.ResultCode
+	txn.Metadata["result_desc"] = result.ResultDesc
+	txn.Metadata["resolved_at"] = time.Now().Format(time.RFC3339)
+	if result.ProviderReference != "" {
+		txn.Metadata["provider_reference"] = result.ProviderReference
+	}
+
+	// Either way the funds leave the suspense account
+	_, err = tx.ExecContext(ctx, "UPDATE system_accounts SET balance = balance - ? WHERE account_key = ?", txn.Amount, PayoutSuspenseAccount)
+	if err != nil {
+		return fmt.Errorf("failed to debit payout suspense for %s: %w", txn.ReferenceID, err)
+	}
+
+	status := models.TransactionStatusCompleted
+	if result.Status == payments.StatusFailed {
+		if txn.SenderID == nil {
+			return fmt.Errorf("payout %s has no sender", txn.ReferenceID)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance + ? WHERE id = ?", txn.Amount, *txn.SenderID)
+		if err != nil {
+			return fmt.Errorf("failed to reverse payout %s: %w", txn.ReferenceID, err)
+		}
+		txn.Metadata["reversed"] = true
+		status = models.TransactionStatusFailed
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE transactions SET status = ?, metadata = ? WHERE id = ?", status, txn.Metadata, txn.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update payout %s: %w", txn.ReferenceID, err)
+	}
+
+	if txn.SenderID != nil {
+		template := notifications.TemplatePayoutCompleted
+		if status == models.TransactionStatusFailed {
+			template = notifications.TemplatePayoutFailed
+		}
+		err = s.notifyUser(ctx, tx, *txn.SenderID, template, txn.ReferenceID, map[string]interface{}{
+			"amount": txn.Amount,
+			"msisdn": txn.Metadata["msisdn"],
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// When applied by a consumer, a redelivery of the same message cannot credit twice
+	if err := inbox.Record(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit payout %s: %w", txn.ReferenceID, err)
+	}
+
+	logger.WithCtx(ctx).Infof("payout %s %s", txn.ReferenceID, status)
+	return nil
+}
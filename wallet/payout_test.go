@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/payments"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectRefund expects payout 7 of user 3 to be failed and its funds returned
+func expectRefund(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("UPDATE system_accounts SET balance = balance - \\?").WithArgs(100.0, PayoutSuspenseAccount).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET balance = balance \\+ \\?").WithArgs(100.0, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE transactions SET status = \\?").WithArgs(models.TransactionStatusFailed, sqlmock.AnyArg(), int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT phone_number FROM users").WillReturnRows(phoneRows())
+	mock.ExpectCommit()
+}
+
+func TestInitiatePayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider *fakeProvider
+		wantErr  error
+	}{
+		{"accepted request stays pending", &fakeProvider{payout: &payments.PayoutResponse{ProviderReference: "P1", Status: payments.StatusPending}}, nil},
+		// The provider may have sent the money, so refunding could pay out twice
+		{"unknown outcome is not refunded", &fakeProvider{payoutErr: errors.New("provider returned 503")}, nil},
+		{"rejected request is refunded", &fakeProvider{payoutErr: payments.ErrRejected}, payments.ErrRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, notifier := newTestService(t, Config{}, tt.provider)
+			mock.ExpectQuery("FROM users WHERE phone_number = \\?").WillReturnRows(userRows(500))
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE users SET balance = balance - \\? WHERE id = \\? AND balance >= \\?").
+				WithArgs(100.0, int64(3), 100.0).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE system_accounts SET balance = balance \\+ \\?").WithArgs(100.0, PayoutSuspenseAccount).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(7, 1))
+			mock.ExpectCommit()
+			switch {
+			case tt.provider.payout != nil:
+				mock.ExpectExec("UPDATE transactions SET metadata = \\?").WillReturnResult(sqlmock.NewResult(0, 1))
+			case tt.wantErr != nil:
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WillReturnRows(transactionRows(7, "", models.TransactionTypeWithdrawal, int64(3), nil,
+					models.TransactionStatusPending, `{"method":"mobile_money"}`))
+				expectRefund(mock)
+			}
+
+			txn, err := s.InitiatePayout(context.Background(), "254700000003", 100)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("InitiatePayout = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && txn.Status != models.TransactionStatusPending {
+				t.Errorf("payout is %s, want it pending", txn.Status)
+			}
+			if tt.wantErr != nil && !slices.Equal(notifier.templates, []string{notifications.TemplatePayoutFailed}) {
+				t.Errorf("notified %v, want the payout failure", notifier.templates)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestApplyPayoutResult(t *testing.T) {
+	tests := []struct {
+		name          string
+		stored        models.TransactionStatus
+		status        payments.Status
+		wantTemplates []string
+	}{
+		{"completed settles the suspense account", models.TransactionStatusPending, payments.StatusCompleted, []string{notifications.TemplatePayoutCompleted}},
+		{"failed refunds the wallet", models.TransactionStatusPending, payments.StatusFailed, []string{notifications.TemplatePayoutFailed}},
+		{"settled payout is not refunded again", models.TransactionStatusFailed, payments.StatusFailed, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, notifier := newTestService(t, Config{}, nil)
+			mock.ExpectBegin()
+			mock.ExpectQuery(lockQuery).WithArgs("PO1", models.TransactionTypeWithdrawal).
+				WillReturnRows(transactionRows(7, "PO1", models.TransactionTypeWithdrawal, int64(3), nil, tt.stored, `{"method":"mobile_money"}`))
+			switch {
+			case tt.stored != models.TransactionStatusPending:
+				mock.ExpectRollback()
+			case tt.status == payments.StatusFailed:
+				expectRefund(mock)
+			default:
+				mock.ExpectExec("UPDATE system_accounts SET balance = balance - \\?").WithArgs(100.0, PayoutSuspenseAccount).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE transactions SET status = \\?").WithArgs(models.TransactionStatusCompleted, sqlmock.AnyArg(), int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT phone_number FROM users").WillReturnRows(phoneRows())
+				mock.ExpectCommit()
+			}
+
+			if err := s.ApplyPayoutResult(context.Background(), payments.Result{Reference: "PO1", Status: tt.status}); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(notifier.templates, tt.wantTemplates) {
+				t.Errorf("notified %v, want %v", notifier.templates, tt.wantTemplates)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"ussd-wrapper/models"
+	"ussd-wrapper/payments"
+)
+
+// PendingTransaction is a provider backed transaction still waiting for a final status
+type PendingTransaction struct {
+	ID        int64
+	Reference string
+	Type      models.TransactionType
+	Method    string
+	Attempts  int
+	Escalated bool
+	CreatedAt time.Time
+}
+
+// ReconcileAttempt is appended to a transaction's metadata every time it is reconciled
+type ReconcileAttempt struct {
+	At     time.Time `json:"at"`
+	Status string    `json:"status"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+const pendingSelect = "SELECT id, reference_id, transaction_type, COALESCE(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.method')), ''), " +
+	"COALESCE(JSON_EXTRACT(metadata, '$.reconcile_attempts'), 0), COALESCE(JSON_EXTRACT(metadata, '$.escalated') = true, false), created_at " +
+	"FROM transactions WHERE status = ? AND (transaction_type = ? OR " +
+	"(transaction_type = ? AND JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.method')) = ?))"
+
+// ListStalePending returns pending deposits and mobile money withdrawals older than olderThan.
+// ATM and agent withdrawals are excluded because they stay pending until their code is redeemed or expires.
+func (s *Service) ListStalePending(ctx context.Context, olderThan time.Duration, limit int) ([]PendingTransaction, error) {
+	rows, err := s.db.QueryContext(ctx, pendingSelect+" AND created_at < ? ORDER BY id LIMIT ?",
+		models.TransactionStatusPending, models.TransactionTypeDeposit, models.TransactionTypeWithdrawal, payoutMethod,
+		time.Now().Add(-olderThan), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []PendingTransaction
+	for rows.Next() {
+		var p PendingTransaction
+		if err := rows.Scan(&p.ID, &p.Reference, &p.Type, &p.Method, &p.Attempts, &p.Escalated, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pending transaction: %w", err)
+		}
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+// GetPendingTransaction returns the pending provider backed transaction with the given
+// reference, or ErrTransactionNotFound when there is none
+func (s *Service) GetPendingTransaction(ctx context.Context, reference string) (*PendingTransaction, error) {
+	var p PendingTransaction
+	err := s.db.QueryRowContext(ctx, pendingSelect+" AND reference_id = ?",
+		models.TransactionStatusPending, models.TransactionTypeDeposit, models.TransactionTypeWithdrawal, payoutMethod, reference).
+		Scan(&p.ID, &p.Reference, &p.Type, &p.Method, &p.Attempts, &p.Escalated, &p.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending transaction %s: %w", reference, err)
+	}
+	return &p, nil
+}
+
+// QueryProviderStatus asks the provider adapter behind a pending transaction for its current status.
+// A reference unknown to the provider is reported as a pending result.
+func (s *Service) QueryProviderStatus(ctx context.Context, p PendingTransaction) (*payments.Result, error) {
+	var result *payments.Result
+	var err error
+
+	switch p.Type {
+	case models.TransactionTypeDeposit:
+		result, err = s.provider.QueryDeposit(ctx, p.Reference)
+	case models.TransactionTypeWithdrawal:
+		result, err = s.payouts.QueryPayout(ctx, p.Reference)
+	default:
+		return nil, fmt.Errorf("no provider adapter for %s transactions", p.Type)
+	}
+
+	if errors.Is(err, payments.ErrUnknownReference) {
+		return &payments.Result{Reference: p.Reference, Status: payments.StatusPending, ResultDesc: err.Error()}, nil
+	}
+	return result, err
+}
+
+// ApplyProviderResult completes a pending transaction, or fails it (reversing
+// any debited funds) according to a final provider result
+func (s *Service) ApplyProviderResult(ctx context.Context, p PendingTransaction, result payments.Result) error {
+	switch p.Type {
+	case models.TransactionTypeDeposit:
+		return s.ApplyDepositResult(ctx, result)
+	case models.TransactionTypeWithdrawal:
+		return s.ApplyPayoutResult(ctx, result)
+	default:
+		return fmt.Errorf("no provider adapter for %s transactions", p.Type)
+	}
+}
+
+// RecordReconcileAttempt appends an attempt to the transaction metadata and
+// returns the new attempt count. escalate marks the transaction as escalated.
+func (s *Service) RecordReconcileAttempt(ctx context.Context, id int64, attempt ReconcileAttempt, escalate bool) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var metadata models.JSONMap
+	err = tx.QueryRowContext(ctx, "SELECT metadata FROM transactions WHERE id = ? FOR UPDATE", id).Scan(&metadata)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrTransactionNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load transaction %d: %w", id, err)
+	}
+	if metadata == nil {
+		metadata = make(models.JSONMap)
+	}
+
+	attempts := 1
+	if n, ok := metadata["reconcile_attempts"].(float64); ok {
+		attempts = int(n) + 1
+	}
+
+	log, _ := metadata["reconcile_log"].([]interface{})
+	log = append(log, attempt)
+
+	metadata["reconcile_attempts"] = attempts
+	metadata["reconcile_log"] = log
+	metadata["last_reconciled_at"] = attempt.At.Format(time.RFC3339)
+	if escalate {
+		metadata["escalated"] = true
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE transactions SET metadata = ? WHERE id = ?", metadata, id); err != nil {
+		return 0, fmt.Errorf("failed to record reconcile attempt for %d: %w", id, err)
+	}
+
+	return attempts, tx.Commit()
+}
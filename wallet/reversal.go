@@ -0,0 +1,416 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrNotReversible is returned for transactions that cannot be reversed
+	ErrNotReversible = errors.New("transaction cannot be reversed")
+
+	// ErrReversalWindowClosed is returned when a reversal is requested too long after the transaction
+	ErrReversalWindowClosed = errors.New("reversal window has closed")
+
+	// ErrReversalExists is returned when the transaction already has an open or approved reversal
+	ErrReversalExists = errors.New("reversal already requested")
+
+	// ErrReversalNotFound is returned when a reversal request ID does not exist
+	ErrReversalNotFound = errors.New("reversal request not found")
+
+	// ErrReversalClosed is returned when acting on an approved or rejected request
+	ErrReversalClosed = errors.New("reversal request already closed")
+
+	// ErrSameApprover is returned when the checker is the admin who reviewed the request
+	ErrSameApprover = errors.New("reversal must be approved by a different admin")
+)
+
+// ReversalWindow is how long after a transaction its sender may request a reversal
+func (s *Service) ReversalWindow() time.Duration {
+	return time.Duration(s.config.ReversalWindowHours) * time.Hour
+}
+
+// GetReversibleTransaction returns a completed transfer sent by phoneNumber that is still inside the reversal window
+func (s *Service) GetReversibleTransaction(ctx context.Context, phoneNumber, reference string) (*models.Transaction, error) {
+	user, err := s.GetUserByPhone(ctx, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var txn models.Transaction
+	var senderID, recipientID sql.NullInt64
+	var recipientPhone sql.NullString
+
+	err = s.dbSlave.QueryRowContext(ctx,
+		"SELECT t.id, t.reference_id, t.transaction_type, t.sender_id, t.recipient_id, t.amount, t.status, t.created_at, u.phone_number "+
+			"FROM transactions t LEFT JOIN users u ON u.id = t.recipient_id WHERE t.reference_id = ?",
+		reference).Scan(&txn.ID, &txn.ReferenceID, &txn.Type, &senderID, &recipientID, &txn.Amount, &txn.Status, &txn.CreatedAt, &recipientPhone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction %s: %w", reference, err)
+	}
+
+	// Only the sender may see or reverse their own transactions
+	if !senderID.Valid || senderID.Int64 != user.ID {
+		return nil, ErrTransactionNotFound
+	}
+	txn.SenderID = &senderID.Int64
+	if recipientID.Valid {
+		txn.RecipientID = &recipientID.Int64
+	}
+	txn.SenderPhone = phoneNumber
+	txn.RecipientPhone = recipientPhone.String
+
+	if txn.Type != models.TransactionTypeTransfer || txn.Status != models.TransactionStatusCompleted || txn.RecipientID == nil {
+		return nil, ErrNotReversible
+	}
+
+	if time.Since(txn.CreatedAt) > s.ReversalWindow() {
+		return nil, ErrReversalWindowClosed
+	}
+
+	return &txn, nil
+}
+
+// RequestReversal queues a reversal of the caller's transfer for admin approval
+func (s *Service) RequestReversal(ctx context.Context, phoneNumber, reference, reason string) (*models.ReversalRequest, error) {
+	ctx, span := s.tracer.Start(ctx, "RequestReversal",
+		trace.WithAttributes(attribute.String("reference", reference)))
+	defer span.End()
+
+	txn, err := s.GetReversibleTransaction(ctx, phoneNumber, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the original so concurrent requests for it serialise here
+	var reversed sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		"SELECT (SELECT id FROM transactions WHERE reversal_of = t.id) FROM transactions t WHERE t.id = ? FOR UPDATE",
+		txn.ID).Scan(&reversed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock transaction %s: %w", reference, err)
+	}
+	if reversed.Valid {
+		return nil, ErrReversalExists
+	}
+
+	var open int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM reversal_requests WHERE transaction_id = ? AND status <> ?",
+		txn.ID, models.ReversalStatusRejected).Scan(&open)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check reversal requests for %s: %w", reference, err)
+	}
+	if open > 0 {
+		return nil, ErrReversalExists
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO reversal_requests (transaction_id, requested_by, reason) VALUES (?, ?, ?)",
+		txn.ID, *txn.SenderID, reason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record reversal request for %s: %w", reference, err)
+	}
+	id, _ := res.LastInsertId()
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit reversal request for %s: %w", reference, err)
+	}
+
+	logger.WithCtx(ctx).Infof("reversal of %s requested by %s", reference, phoneNumber)
+
+	return &models.ReversalRequest{
+		ID:                   id,
+		TransactionID:        txn.ID,
+		TransactionReference: txn.ReferenceID,
+		RequestedBy:          *txn.SenderID,
+		Reason:               reason,
+		Status:               models.ReversalStatusPending,
+		Amount:               txn.Amount,
+		CreatedAt:            time.Now(),
+	}, nil
+}
+
+// ListReversalRequests returns reversal requests, optionally filtered by status, newest first
+func (s *Service) ListReversalRequests(ctx context.Context, status string, limit int) ([]models.ReversalRequest, error) {
+	query := reversalSelect
+	var args []interface{}
+	if status != "" {
+		query += " WHERE r.status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY r.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.dbSlave.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reversal requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := make([]models.ReversalRequest, 0)
+	for rows.Next() {
+		req, err := scanReversalRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+
+	return requests, rows.Err()
+}
+
+// ApproveReversal records an admin approval. With maker-checker enabled the first
+// approval only marks the request as reviewed and a second admin executes it.
+func (s *Service) ApproveReversal(ctx context.Context, id int64, admin, note string) (*models.ReversalRequest, error) {
+	ctx, span := s.tracer.Start(ctx, "ApproveReversal",
+		trace.WithAttributes(attribute.Int64("reversal_request_id", id)))
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	req, err := lockReversalRequest(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	oldStatus := req.Status
+	switch {
+	case req.Status != models.ReversalStatusPending && req.Status != models.ReversalStatusReviewed:
+		return nil, ErrReversalClosed
+
+	case s.config.ReversalMakerChecker && req.Status == models.ReversalStatusPending:
+		_, err = tx.ExecContext(ctx,
+			"UPDATE reversal_requests SET status = ?, reviewed_by = ?, review_note = ? WHERE id = ?",
+			models.ReversalStatusReviewed, admin, note, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to review reversal request %d: %w", id, err)
+		}
+		req.Status = models.ReversalStatusReviewed
+		req.ReviewedBy = admin
+		req.ReviewNote = note
+
+	case s.config.ReversalMakerChecker && req.ReviewedBy == admin:
+		return nil, ErrSameApprover
+
+	default:
+		reversalID, err := s.executeReversal(ctx, tx, req, admin)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"UPDATE reversal_requests SET status = ?, approved_by = ?, review_note = ?, reversal_transaction_id = ? WHERE id = ?",
+			models.ReversalStatusApproved, admin, note, reversalID, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to approve reversal request %d: %w", id, err)
+		}
+		req.Status = models.ReversalStatusApproved
+		req.ApprovedBy = admin
+		req.ReviewNote = note
+		req.ReversalTransactionID = &reversalID
+	}
+
+	if err := recordReversalAudit(ctx, tx, req, oldStatus, admin); err != nil {
+		return nil, err
+	}
+
+	if req.Status == models.ReversalStatusApproved {
+		if err := s.notifyReversal(ctx, tx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit reversal request %d: %w", id, err)
+	}
+
+	return req, nil
+}
+
+// RejectReversal closes a reversal request without moving any money
+func (s *Service) RejectReversal(ctx context.Context, id int64, admin, note string) (*models.ReversalRequest, error) {
+	ctx, span := s.tracer.Start(ctx, "RejectReversal",
+		trace.WithAttributes(attribute.Int64("reversal_request_id", id)))
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	req, err := lockReversalRequest(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Status != models.ReversalStatusPending && req.Status != models.ReversalStatusReviewed {
+		return nil, ErrReversalClosed
+	}
+
+	oldStatus := req.Status
+	_, err = tx.ExecContext(ctx,
+		"UPDATE reversal_requests SET status = ?, approved_by = ?, review_note = ? WHERE id = ?",
+		models.ReversalStatusRejected, admin, note, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reject reversal request %d: %w", id, err)
+	}
+	req.Status = models.ReversalStatusRejected
+	req.ApprovedBy = admin
+	req.ReviewNote = note
+
+	if err := recordReversalAudit(ctx, tx, req, oldStatus, admin); err != nil {
+		return nil, err
+	}
+
+	if err := s.notifyUser(ctx, tx, req.RequestedBy, notifications.TemplateReversalRejected, req.TransactionReference, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit reversal request %d: %w", id, err)
+	}
+
+	return req, nil
+}
+
+// executeReversal moves the funds back through a new compensating transaction.
+// The original transaction row is never modified.
+func (s *Service) executeReversal(ctx context.Context, tx *sql.Tx, req *models.ReversalRequest, admin string) (int64, error) {
+	original, err := lockTransaction(ctx, tx, req.TransactionReference, models.TransactionTypeTransfer)
+	if err != nil {
+		return 0, err
+	}
+	if original.SenderID == nil || original.RecipientID == nil {
+		return 0, ErrNotReversible
+	}
+
+	// The recipient must still hold the funds; the request stays open otherwise
+	res, err := tx.ExecContext(ctx, "UPDATE users SET balance = balance - ? WHERE id = ? AND balance >= ?",
+		original.Amount, *original.RecipientID, original.Amount)
+	if err != nil {
+		return 0, fmt.Errorf("failed to debit recipient of %s: %w", original.ReferenceID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return 0, ErrInsufficientFunds
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance + ? WHERE id = ?", original.Amount, *original.SenderID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to credit sender of %s: %w", original.ReferenceID, err)
+	}
+
+	reference := library.GenerateTransactionID("RV")
+	metadata := models.JSONMap{
+		"original_reference":  original.ReferenceID,
+		"reversal_request_id": req.ID,
+		"approved_by":         admin,
+		"currency":            s.config.Currency,
+	}
+	res, err = tx.ExecContext(ctx,
+		"INSERT INTO transactions (reference_id, transaction_type, sender_id, recipient_id, reversal_of, amount, status, description, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		reference, models.TransactionTypeReversal, *original.RecipientID, *original.SenderID, original.ID, original.Amount,
+		models.TransactionStatusCompleted, "Reversal of "+original.ReferenceID, metadata)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record reversal of %s: %w", original.ReferenceID, err)
+	}
+
+	logger.WithCtx(ctx).Infof("transaction %s reversed by %s approved by %s", original.ReferenceID, reference, admin)
+	return res.LastInsertId()
+}
+
+// notifyReversal tells both parties of an approved reversal
+func (s *Service) notifyReversal(ctx context.Context, tx *sql.Tx, req *models.ReversalRequest) error {
+	var senderPhone, recipientPhone string
+	err := tx.QueryRowContext(ctx,
+		"SELECT s.phone_number, r.phone_number FROM transactions t JOIN users s ON s.id = t.sender_id JOIN users r ON r.id = t.recipient_id WHERE t.id = ?",
+		req.TransactionID).Scan(&senderPhone, &recipientPhone)
+	if err != nil {
+		return fmt.Errorf("failed to load parties for reversal %d: %w", req.ID, err)
+	}
+
+	data := map[string]interface{}{"amount": req.Amount}
+	if err := s.notify(ctx, tx, senderPhone, notifications.TemplateReversalRefunded, req.TransactionReference, data); err != nil {
+		return err
+	}
+	return s.notify(ctx, tx, recipientPhone, notifications.TemplateReversalDebited, req.TransactionReference, data)
+}
+
+func recordReversalAudit(ctx context.Context, tx *sql.Tx, req *models.ReversalRequest, oldStatus models.ReversalStatus, admin string) error {
+	return library.RecordAudit(ctx, tx, models.AuditLog{
+		Action:     "reversal_" + string(req.Status),
+		EntityType: "reversal_request",
+		EntityID:   strconv.FormatInt(req.ID, 10),
+		OldValue:   models.JSONMap{"status": oldStatus},
+		NewValue: models.JSONMap{
+			"status": req.Status,
+			"admin":  admin,
+			"note":   req.ReviewNote,
+		},
+	})
+}
+
+const reversalSelect = "SELECT r.id, r.transaction_id, t.reference_id, r.requested_by, r.reason, r.status, r.reviewed_by, r.approved_by, " +
+	"r.review_note, r.reversal_transaction_id, t.amount, r.created_at, r.updated_at FROM reversal_requests r JOIN transactions t ON t.id = r.transaction_id"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanReversalRequest(row rowScanner) (*models.ReversalRequest, error) {
+	var req models.ReversalRequest
+	var reason, reviewedBy, approvedBy, note sql.NullString
+	var reversalID sql.NullInt64
+
+	err := row.Scan(&req.ID, &req.TransactionID, &req.TransactionReference, &req.RequestedBy, &reason, &req.Status,
+		&reviewedBy, &approvedBy, &note, &reversalID, &req.Amount, &req.CreatedAt, &req.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Reason = reason.String
+	req.ReviewedBy = reviewedBy.String
+	req.ApprovedBy = approvedBy.String
+	req.ReviewNote = note.String
+	if reversalID.Valid {
+		req.ReversalTransactionID = &reversalID.Int64
+	}
+
+	return &req, nil
+}
+
+func lockReversalRequest(ctx context.Context, tx *sql.Tx, id int64) (*models.ReversalRequest, error) {
+	req, err := scanReversalRequest(tx.QueryRowContext(ctx, reversalSelect+" WHERE r.id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReversalNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reversal request %d: %w", id, err)
+	}
+	return req, nil
+}
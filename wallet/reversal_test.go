@@ -0,0 +1,73 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"ussd-wrapper/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestApproveReversal(t *testing.T) {
+	tests := []struct {
+		name         string
+		makerChecker bool
+		status       models.ReversalStatus
+		reviewedBy   interface{}
+		admin        string
+		wantStatus   models.ReversalStatus
+		wantErr      error
+	}{
+		{"single approval executes without maker-checker", false, models.ReversalStatusPending, nil, "alice", models.ReversalStatusApproved, nil},
+		{"first approval only reviews", true, models.ReversalStatusPending, nil, "alice", models.ReversalStatusReviewed, nil},
+		{"reviewer cannot also approve", true, models.ReversalStatusReviewed, "alice", "alice", "", ErrSameApprover},
+		{"second admin executes", true, models.ReversalStatusReviewed, "alice", "bob", models.ReversalStatusApproved, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{ReversalMakerChecker: tt.makerChecker}, nil)
+			mock.ExpectBegin()
+			mock.ExpectQuery("FROM reversal_requests r JOIN transactions t ON t.id = r.transaction_id WHERE r.id = \\? FOR UPDATE").
+				WithArgs(int64(5)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "reference_id", "requested_by", "reason", "status", "reviewed_by", "approved_by",
+					"review_note", "reversal_transaction_id", "amount", "created_at", "updated_at"}).
+					AddRow(5, 9, "TR1", 3, "wrong number", tt.status, tt.reviewedBy, nil, nil, nil, 100.0, time.Now(), time.Now()))
+			switch tt.wantStatus {
+			case models.ReversalStatusReviewed:
+				mock.ExpectExec("UPDATE reversal_requests SET status = \\?, reviewed_by = \\?").
+					WithArgs(models.ReversalStatusReviewed, tt.admin, "ok", int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			case models.ReversalStatusApproved:
+				mock.ExpectQuery(lockQuery).WithArgs("TR1", models.TransactionTypeTransfer).
+					WillReturnRows(transactionRows(9, "TR1", models.TransactionTypeTransfer, int64(3), int64(4), models.TransactionStatusCompleted, "{}"))
+				mock.ExpectExec("UPDATE users SET balance = balance - \\? WHERE id = \\? AND balance >= \\?").
+					WithArgs(100.0, int64(4), 100.0).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE users SET balance = balance \\+ \\?").WithArgs(100.0, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+				mock.ExpectExec("UPDATE reversal_requests SET status = \\?, approved_by = \\?").
+					WithArgs(models.ReversalStatusApproved, tt.admin, "ok", int64(12), int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("SELECT s.phone_number, r.phone_number FROM transactions").
+					WillReturnRows(sqlmock.NewRows([]string{"sender", "recipient"}).AddRow("254700000003", "254700000004"))
+				mock.ExpectCommit()
+			default:
+				mock.ExpectRollback()
+			}
+
+			req, err := s.ApproveReversal(context.Background(), 5, tt.admin, "ok")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ApproveReversal = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && req.Status != tt.wantStatus {
+				t.Errorf("request is %s, want %s", req.Status, tt.wantStatus)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
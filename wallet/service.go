@@ -0,0 +1,149 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/payments"
+)
+
+var (
+	// ErrAccountNotFound is returned when no wallet exists for a phone number
+	ErrAccountNotFound = errors.New("account not found")
+
+	// ErrTransactionNotFound is returned when a reference does not match a transaction
+	ErrTransactionNotFound = errors.New("transaction not found")
+
+	// ErrInsufficientFunds is returned when the available balance cannot cover a debit or hold
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrRecipientNotFound is returned when a transfer recipient has no wallet
+	ErrRecipientNotFound = errors.New("recipient not found")
+
+	// ErrSelfTransfer is returned when sender and recipient are the same wallet
+	ErrSelfTransfer = errors.New("cannot transfer to own account")
+)
+
+// Config holds the wallet settings
+type Config struct {
+	Currency           string `mapstructure:"currency"`
+	PaymentCallbackURL string `mapstructure:"payment_callback_url"` // where the provider posts deposit results
+	PayoutCallbackURL  string `mapstructure:"payout_callback_url"`  // where the provider posts payout results
+
+	WithdrawalCodeTTL       int `mapstructure:"withdrawal_code_ttl"`       // minutes a withdrawal code can be redeemed
+	WithdrawalSweepInterval int `mapstructure:"withdrawal_sweep_interval"` // seconds between releases of expired holds
+
+	// HMAC key of withdrawal code hashes; changing it invalidates every issued code
+	WithdrawalCodeSecret string `mapstructure:"withdrawal_code_secret"`
+
+	ReversalWindowHours  int  `mapstructure:"reversal_window_hours"`  // how long after a transfer its sender may ask for a reversal
+	ReversalMakerChecker bool `mapstructure:"reversal_maker_checker"` // reversals need two distinct admins
+}
+
+// Service holds the wallet business logic shared by the USSD controller and background workers
+type Service struct {
+	config   Config
+	db       *sql.DB
+	dbSlave  *sql.DB
+	tracer   trace.Tracer
+	provider payments.MobileMoneyProvider
+	payouts  payments.PayoutProvider
+	notifier notifications.Notifier
+}
+
+// NewService creates a wallet service with the given dependencies
+func NewService(config Config, db *sql.DB, dbSlave *sql.DB, tracer trace.Tracer, provider payments.MobileMoneyProvider, payouts payments.PayoutProvider, notifier notifications.Notifier) *Service {
+	return &Service{
+		config:   config,
+		db:       db,
+		dbSlave:  dbSlave,
+		tracer:   tracer,
+		provider: provider,
+		payouts:  payouts,
+		notifier: notifier,
+	}
+}
+
+// GetUserByPhone fetches the wallet holder registered with a phone number
+func (s *Service) GetUserByPhone(ctx context.Context, phoneNumber string) (*models.User, error) {
+	var user models.User
+	var firstName, lastName sql.NullString
+
+	err := s.dbSlave.QueryRowContext(ctx,
+		"SELECT id, phone_number, first_name, last_name, language, balance, held_balance, status, created_at, updated_at FROM users WHERE phone_number = ?",
+		phoneNumber).Scan(&user.ID, &user.PhoneNumber, &firstName, &lastName, &user.Language, &user.Balance, &user.HeldBalance, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user %s: %w", phoneNumber, err)
+	}
+
+	user.FirstName = firstName.String
+	user.LastName = lastName.String
+	return &user, nil
+}
+
+// notify writes a templated receipt to the outbox within tx, so it is sent if and only if
+// the money movement commits. The reference and currency placeholders are always filled in.
+func (s *Service) notify(ctx context.Context, tx *sql.Tx, msisdn, template, reference string, data map[string]interface{}) error {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["reference"] = reference
+	if _, ok := data["currency"]; !ok {
+		data["currency"] = s.config.Currency
+	}
+
+	return s.notifier.Notify(ctx, tx, notifications.Notification{
+		Template:  template,
+		MSISDN:    msisdn,
+		Reference: reference,
+		Data:      data,
+	})
+}
+
+// notifyUser writes a receipt for the wallet holder with the given id
+func (s *Service) notifyUser(ctx context.Context, tx *sql.Tx, userID int64, template, reference string, data map[string]interface{}) error {
+	var phone string
+	if err := tx.QueryRowContext(ctx, "SELECT phone_number FROM users WHERE id = ?", userID).Scan(&phone); err != nil {
+		return fmt.Errorf("failed to fetch user %d: %w", userID, err)
+	}
+	return s.notify(ctx, tx, phone, template, reference, data)
+}
+
+// lockTransaction loads a transaction by reference and locks the row for the rest of tx
+func lockTransaction(ctx context.Context, tx *sql.Tx, reference string, txType models.TransactionType) (*models.Transaction, error) {
+	var txn models.Transaction
+	var senderID, recipientID sql.NullInt64
+	var description sql.NullString
+
+	err := tx.QueryRowContext(ctx,
+		"SELECT id, reference_id, transaction_type, sender_id, recipient_id, amount, fee, status, description, metadata, created_at, updated_at "+
+			"FROM transactions WHERE reference_id = ? AND transaction_type = ? FOR UPDATE",
+		reference, txType).Scan(&txn.ID, &txn.ReferenceID, &txn.Type, &senderID, &recipientID, &txn.Amount, &txn.Fee,
+		&txn.Status, &description, &txn.Metadata, &txn.CreatedAt, &txn.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transaction %s: %w", reference, err)
+	}
+
+	if senderID.Valid {
+		txn.SenderID = &senderID.Int64
+	}
+	if recipientID.Valid {
+		txn.RecipientID = &recipientID.Int64
+	}
+	txn.Description = description.String
+	if txn.Metadata == nil {
+		txn.Metadata = make(models.JSONMap)
+	}
+
+	return &txn, nil
+}
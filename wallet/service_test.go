@@ -0,0 +1,90 @@
+package wallet
+
+import (
+	"context"
+	"testing"
+	"time"
+	"ussd-wrapper/library"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+	"ussd-wrapper/payments"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel"
+)
+
+// fakeProvider answers deposit and payout requests with fixed responses
+type fakeProvider struct {
+	deposit    *payments.DepositResponse
+	depositErr error
+	payout     *payments.PayoutResponse
+	payoutErr  error
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) InitiateDeposit(ctx context.Context, req payments.DepositRequest) (*payments.DepositResponse, error) {
+	return p.deposit, p.depositErr
+}
+
+func (p *fakeProvider) QueryDeposit(ctx context.Context, reference string) (*payments.Result, error) {
+	return nil, payments.ErrUnknownReference
+}
+
+func (p *fakeProvider) ParseDepositCallback(body []byte) (*payments.Result, error) {
+	return nil, payments.ErrUnknownReference
+}
+
+func (p *fakeProvider) InitiatePayout(ctx context.Context, req payments.PayoutRequest) (*payments.PayoutResponse, error) {
+	return p.payout, p.payoutErr
+}
+
+func (p *fakeProvider) QueryPayout(ctx context.Context, reference string) (*payments.Result, error) {
+	return nil, payments.ErrUnknownReference
+}
+
+func (p *fakeProvider) ParsePayoutCallback(body []byte) (*payments.Result, error) {
+	return nil, payments.ErrUnknownReference
+}
+
+// fakeNotifier records the templates of the notifications it is given
+type fakeNotifier struct {
+	templates []string
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, db library.Execer, notification notifications.Notification) error {
+	n.templates = append(n.templates, notification.Template)
+	return nil
+}
+
+func newTestService(t *testing.T, config Config, provider *fakeProvider) (*Service, sqlmock.Sqlmock, *fakeNotifier) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if provider == nil {
+		provider = &fakeProvider{}
+	}
+	config.Currency = "KES"
+	notifier := &fakeNotifier{}
+	return NewService(config, db, db, otel.Tracer("test"), provider, provider, notifier), mock, notifier
+}
+
+const lockQuery = "FROM transactions WHERE reference_id = \\? AND transaction_type = \\? FOR UPDATE"
+
+// transactionRows is a transaction of 100 as lockTransaction reads it
+func transactionRows(id int64, reference string, txType models.TransactionType, senderID, recipientID interface{}, status models.TransactionStatus, metadata string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "reference_id", "transaction_type", "sender_id", "recipient_id", "amount", "fee", "status", "description", "metadata", "created_at", "updated_at"}).
+		AddRow(id, reference, txType, senderID, recipientID, 100.0, 0.0, status, nil, []byte(metadata), time.Now(), time.Now())
+}
+
+func phoneRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"phone_number"}).AddRow("254700000003")
+}
+
+func userRows(balance float64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "phone_number", "first_name", "last_name", "language", "balance", "held_balance", "status", "created_at", "updated_at"}).
+		AddRow(3, "254700000003", "Jane", "Doe", "en", balance, 0.0, "active", time.Now(), time.Now())
+}
@@ -0,0 +1,108 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transfer moves amount between two wallets and records a completed transfer
+func (s *Service) Transfer(ctx context.Context, senderPhone, recipientPhone string, amount float64) (*models.Transaction, *models.User, error) {
+	ctx, span := s.tracer.Start(ctx, "Transfer",
+		trace.WithAttributes(attribute.Float64("amount", amount)))
+	defer span.End()
+
+	sender, err := s.GetUserByPhone(ctx, senderPhone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recipient, err := s.GetUserByPhone(ctx, recipientPhone)
+	if errors.Is(err, ErrAccountNotFound) {
+		return nil, nil, ErrRecipientNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sender.ID == recipient.ID {
+		return nil, nil, ErrSelfTransfer
+	}
+
+	reference := library.GenerateTransactionID("TR")
+	metadata := models.JSONMap{
+		"channel":          "ussd",
+		"currency":         s.config.Currency,
+		"sender_msisdn":    senderPhone,
+		"recipient_msisdn": recipientPhone,
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET balance = balance - ? WHERE id = ? AND balance >= ?", amount, sender.ID, amount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to debit sender for %s: %w", reference, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, nil, ErrInsufficientFunds
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET balance = balance + ? WHERE id = ?", amount, recipient.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to credit recipient for %s: %w", reference, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO transactions (reference_id, transaction_type, sender_id, recipient_id, amount, status, description, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		reference, models.TransactionTypeTransfer, sender.ID, recipient.ID, amount, models.TransactionStatusCompleted, "Wallet transfer", metadata)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to record transfer %s: %w", reference, err)
+	}
+
+	err = s.notify(ctx, tx, senderPhone, notifications.TemplateTransferSent, reference, map[string]interface{}{
+		"amount":       amount,
+		"name":         strings.TrimSpace(recipient.FirstName + " " + recipient.LastName),
+		"counterparty": recipientPhone,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	err = s.notify(ctx, tx, recipientPhone, notifications.TemplateTransferReceived, reference, map[string]interface{}{
+		"amount":       amount,
+		"name":         strings.TrimSpace(sender.FirstName + " " + sender.LastName),
+		"counterparty": senderPhone,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transfer %s: %w", reference, err)
+	}
+
+	logger.WithCtx(ctx).Infof("transfer %s of %.2f from %s to %s completed", reference, amount, senderPhone, recipientPhone)
+
+	return &models.Transaction{
+		ReferenceID:    reference,
+		Type:           models.TransactionTypeTransfer,
+		SenderID:       &sender.ID,
+		RecipientID:    &recipient.ID,
+		Amount:         amount,
+		Status:         models.TransactionStatusCompleted,
+		Metadata:       metadata,
+		SenderPhone:    senderPhone,
+		RecipientPhone: recipientPhone,
+	}, recipient, nil
+}
@@ -0,0 +1,423 @@
+package wallet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+	"ussd-wrapper/library"
+	"ussd-wrapper/library/logger"
+	"ussd-wrapper/models"
+	"ussd-wrapper/notifications"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const withdrawalCodeDigits = 8
+
+var (
+	// ErrInvalidCode is returned when a withdrawal code does not match any issued code
+	ErrInvalidCode = errors.New("invalid withdrawal code")
+
+	// ErrCodeExpired is returned when a withdrawal code is redeemed after its TTL
+	ErrCodeExpired = errors.New("withdrawal code expired")
+
+	// ErrCodeRedeemed is returned when a withdrawal code has already been used
+	ErrCodeRedeemed = errors.New("withdrawal code already redeemed")
+
+	// ErrAmountMismatch is returned when the redeemed amount differs from the issued amount
+	ErrAmountMismatch = errors.New("withdrawal amount does not match code")
+
+	// ErrChannelMismatch is returned when a code is redeemed on a channel it was not issued for
+	ErrChannelMismatch = errors.New("withdrawal code not valid for this channel")
+)
+
+// IssuedWithdrawal is returned to the customer when a withdrawal code is issued.
+// Code is the only place the plain code exists.
+type IssuedWithdrawal struct {
+	Code      string
+	Reference string
+	Amount    float64
+	Channel   models.WithdrawalChannel
+	ExpiresAt time.Time
+}
+
+// RedeemRequest is sent by ATM and agent systems to pay out a withdrawal code
+type RedeemRequest struct {
+	MSISDN     string                   `json:"msisdn"`
+	Code       string                   `json:"code"`
+	Amount     float64                  `json:"amount"`
+	Channel    models.WithdrawalChannel `json:"channel"`
+	TerminalID string                   `json:"terminal_id"`
+}
+
+// hashWithdrawalCode binds a code to the MSISDN it was issued to. The HMAC key stays
+// on the server, so the 8 digit codes cannot be brute forced from a leaked table.
+func (s *Service) hashWithdrawalCode(msisdn, code string) string {
+	mac := hmac.New(sha256.New, []byte(s.config.WithdrawalCodeSecret))
+	mac.Write([]byte(msisdn + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// generateWithdrawalCode returns a random numeric code that can be keyed in at an ATM
+func generateWithdrawalCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(withdrawalCodeDigits), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", withdrawalCodeDigits, n), nil
+}
+
+// IssueWithdrawalCode places amount on hold and issues a one-time code redeemable
+// on the given channel until withdrawal_code_ttl minutes have passed
+func (s *Service) IssueWithdrawalCode(ctx context.Context, phoneNumber string, amount float64, channel models.WithdrawalChannel) (*IssuedWithdrawal, error) {
+	ctx, span := s.tracer.Start(ctx, "IssueWithdrawalCode",
+		trace.WithAttributes(attribute.Float64("amount", amount), attribute.String("channel", string(channel))))
+	defer span.End()
+
+	user, err := s.GetUserByPhone(ctx, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(s.config.WithdrawalCodeTTL) * time.Minute
+	expiresAt := time.Now().Add(ttl)
+	reference := library.GenerateTransactionID("WD")
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users SET balance = balance - ?, held_balance = held_balance + ? WHERE id = ? AND balance >= ?",
+		amount, amount, user.ID, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hold funds for %s: %w", reference, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, ErrInsufficientFunds
+	}
+
+	metadata := models.JSONMap{
+		"channel":    "ussd",
+		"method":     string(channel),
+		"msisdn":     phoneNumber,
+		"currency":   s.config.Currency,
+		"hold":       true,
+		"expires_at": expiresAt.Format(time.RFC3339),
+	}
+	res, err = tx.ExecContext(ctx,
+		"INSERT INTO transactions (reference_id, transaction_type, sender_id, amount, status, description, metadata) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		reference, models.TransactionTypeWithdrawal, user.ID, amount, models.TransactionStatusPending, "Cardless withdrawal", metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record withdrawal %s: %w", reference, err)
+	}
+	transactionID, _ := res.LastInsertId()
+
+	// Retry on the rare hash collision with a previously issued code
+	var code string
+	for attempt := 0; attempt < 3; attempt++ {
+		code, err = generateWithdrawalCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate withdrawal code: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO withdrawal_tokens (code_hash, transaction_id, user_id, msisdn, amount, channel, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			s.hashWithdrawalCode(phoneNumber, code), transactionID, user.ID, phoneNumber, amount, channel, expiresAt)
+
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store withdrawal code for %s: %w", reference, err)
+	}
+
+	// The code is purged from the outbox and sms_messages once the SMS is sent
+	err = s.notifier.Notify(ctx, tx, notifications.Notification{
+		Template:  notifications.TemplateWithdrawalCodeIssued,
+		MSISDN:    phoneNumber,
+		Reference: reference,
+		Data: map[string]interface{}{
+			"amount":     amount,
+			"code":       code,
+			"channel":    string(channel),
+			"expires_at": expiresAt,
+			"reference":  reference,
+			"currency":   s.config.Currency,
+		},
+		Secret: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit withdrawal %s: %w", reference, err)
+	}
+
+	logger.WithCtx(ctx).Infof("withdrawal code issued for %s: %.2f via %s, txn %s", phoneNumber, amount, channel, reference)
+
+	return &IssuedWithdrawal{
+		Code:      code,
+		Reference: reference,
+		Amount:    amount,
+		Channel:   channel,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// RedeemWithdrawalCode verifies a code presented at an ATM or agent and settles the held funds
+func (s *Service) RedeemWithdrawalCode(ctx context.Context, req RedeemRequest) (*models.WithdrawalToken, error) {
+	ctx, span := s.tracer.Start(ctx, "RedeemWithdrawalCode",
+		trace.WithAttributes(attribute.String("channel", string(req.Channel)), attribute.String("terminal_id", req.TerminalID)))
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	token, reference, err := lockWithdrawalToken(ctx, tx, "code_hash = ?", s.hashWithdrawalCode(req.MSISDN, req.Code))
+	if err != nil {
+		return nil, err
+	}
+
+	switch token.Status {
+	case models.WithdrawalTokenRedeemed:
+		return nil, ErrCodeRedeemed
+	case models.WithdrawalTokenExpired:
+		return nil, ErrCodeExpired
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		if err := releaseWithdrawalHold(ctx, tx, token, reference); err != nil {
+			return nil, err
+		}
+		if err := s.notifyExpiredCode(ctx, tx, token, reference); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit expiry of %s: %w", reference, err)
+		}
+		return nil, ErrCodeExpired
+	}
+
+	if req.Channel != "" && req.Channel != token.Channel {
+		return nil, ErrChannelMismatch
+	}
+	if req.Amount > 0 && req.Amount != token.Amount {
+		return nil, ErrAmountMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET held_balance = held_balance - ? WHERE id = ?", token.Amount, token.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to settle hold for %s: %w", reference, err)
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		"UPDATE withdrawal_tokens SET status = ?, redeemed_at = ?, redeemed_by = ? WHERE id = ?",
+		models.WithdrawalTokenRedeemed, now, req.TerminalID, token.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem withdrawal code for %s: %w", reference, err)
+	}
+
+	err = updateTransactionStatus(ctx, tx, token.TransactionID, models.TransactionStatusCompleted, models.JSONMap{
+		"redeemed_at": now.Format(time.RFC3339),
+		"redeemed_by": req.TerminalID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.notify(ctx, tx, token.MSISDN, notifications.TemplateWithdrawalCompleted, reference, map[string]interface{}{
+		"amount":  token.Amount,
+		"channel": string(token.Channel),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit redemption of %s: %w", reference, err)
+	}
+
+	token.Status = models.WithdrawalTokenRedeemed
+	token.RedeemedAt = &now
+	token.RedeemedBy = req.TerminalID
+
+	logger.WithCtx(ctx).Infof("withdrawal %s redeemed by %s", reference, req.TerminalID)
+	return token, nil
+}
+
+// ExpireWithdrawalCodes releases the holds of active codes past their TTL
+func (s *Service) ExpireWithdrawalCodes(ctx context.Context) error {
+	ctx, span := s.tracer.Start(ctx, "ExpireWithdrawalCodes")
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id FROM withdrawal_tokens WHERE status = ? AND expires_at < ? ORDER BY id LIMIT 100",
+		models.WithdrawalTokenActive, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to fetch expired withdrawal codes: %w", err)
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan withdrawal code: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := s.expireWithdrawalCode(ctx, id); err != nil {
+			logger.WithCtx(ctx).Errorf("failed to expire withdrawal code %d: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) expireWithdrawalCode(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	token, reference, err := lockWithdrawalToken(ctx, tx, "id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	// Redeemed between the scan and the lock
+	if token.Status != models.WithdrawalTokenActive {
+		return nil
+	}
+
+	if err := releaseWithdrawalHold(ctx, tx, token, reference); err != nil {
+		return err
+	}
+	if err := s.notifyExpiredCode(ctx, tx, token, reference); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit expiry of %s: %w", reference, err)
+	}
+
+	logger.WithCtx(ctx).Infof("withdrawal code for %s expired, %.2f released", reference, token.Amount)
+	return nil
+}
+
+func (s *Service) notifyExpiredCode(ctx context.Context, tx *sql.Tx, token *models.WithdrawalToken, reference string) error {
+	return s.notify(ctx, tx, token.MSISDN, notifications.TemplateWithdrawalCodeExpired, reference, map[string]interface{}{
+		"amount": token.Amount,
+	})
+}
+
+// WatchWithdrawalCodes periodically releases the holds of expired withdrawal codes
+func (s *Service) WatchWithdrawalCodes(ctx context.Context) {
+	interval := time.Duration(s.config.WithdrawalSweepInterval) * time.Second
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ExpireWithdrawalCodes(ctx); err != nil {
+				logger.WithCtx(ctx).Errorf("withdrawal code sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+// lockWithdrawalToken loads a token and the reference of its transaction, locking the token row
+func lockWithdrawalToken(ctx context.Context, tx *sql.Tx, where string, arg interface{}) (*models.WithdrawalToken, string, error) {
+	var token models.WithdrawalToken
+	var reference string
+	var redeemedAt sql.NullTime
+	var redeemedBy sql.NullString
+
+	err := tx.QueryRowContext(ctx,
+		"SELECT w.id, w.transaction_id, w.user_id, w.msisdn, w.amount, w.channel, w.status, w.expires_at, w.redeemed_at, w.redeemed_by, w.created_at, t.reference_id "+
+			"FROM withdrawal_tokens w JOIN transactions t ON t.id = w.transaction_id WHERE w."+where+" FOR UPDATE",
+		arg).Scan(&token.ID, &token.TransactionID, &token.UserID, &token.MSISDN, &token.Amount, &token.Channel, &token.Status,
+		&token.ExpiresAt, &redeemedAt, &redeemedBy, &token.CreatedAt, &reference)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrInvalidCode
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load withdrawal code: %w", err)
+	}
+
+	if redeemedAt.Valid {
+		token.RedeemedAt = &redeemedAt.Time
+	}
+	token.RedeemedBy = redeemedBy.String
+
+	return &token, reference, nil
+}
+
+// releaseWithdrawalHold returns held funds to the available balance and fails the withdrawal
+func releaseWithdrawalHold(ctx context.Context, tx *sql.Tx, token *models.WithdrawalToken, reference string) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE users SET balance = balance + ?, held_balance = held_balance - ? WHERE id = ?",
+		token.Amount, token.Amount, token.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to release hold for %s: %w", reference, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE withdrawal_tokens SET status = ? WHERE id = ?", models.WithdrawalTokenExpired, token.ID)
+	if err != nil {
+		return fmt.Errorf("failed to expire withdrawal code for %s: %w", reference, err)
+	}
+
+	return updateTransactionStatus(ctx, tx, token.TransactionID, models.TransactionStatusFailed, models.JSONMap{
+		"result_desc": "withdrawal code expired",
+		"resolved_at": time.Now().Format(time.RFC3339),
+	})
+}
+
+// updateTransactionStatus sets the status of a transaction and merges extra metadata into it
+func updateTransactionStatus(ctx context.Context, tx *sql.Tx, id int64, status models.TransactionStatus, extra models.JSONMap) error {
+	var metadata models.JSONMap
+	if err := tx.QueryRowContext(ctx, "SELECT metadata FROM transactions WHERE id = ? FOR UPDATE", id).Scan(&metadata); err != nil {
+		return fmt.Errorf("failed to load transaction %d: %w", id, err)
+	}
+	if metadata == nil {
+		metadata = make(models.JSONMap)
+	}
+	for k, v := range extra {
+		metadata[k] = v
+	}
+
+	_, err := tx.ExecContext(ctx, "UPDATE transactions SET status = ?, metadata = ? WHERE id = ?", status, metadata, id)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"ussd-wrapper/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testCodeSecret = "0123456789abcdef0123456789abcdef"
+
+func TestRedeemWithdrawalCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    models.WithdrawalTokenStatus
+		expiresAt time.Time
+		channel   models.WithdrawalChannel
+		wantErr   error
+	}{
+		{"valid code settles the hold", models.WithdrawalTokenActive, time.Now().Add(time.Hour), models.WithdrawalChannelATM, nil},
+		{"redeemed code cannot be used twice", models.WithdrawalTokenRedeemed, time.Now().Add(time.Hour), models.WithdrawalChannelATM, ErrCodeRedeemed},
+		{"wrong channel", models.WithdrawalTokenActive, time.Now().Add(time.Hour), models.WithdrawalChannelAgent, ErrChannelMismatch},
+		{"code past its ttl releases the hold", models.WithdrawalTokenActive, time.Now().Add(-time.Minute), models.WithdrawalChannelATM, ErrCodeExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{WithdrawalCodeSecret: testCodeSecret}, nil)
+			mock.ExpectBegin()
+			mock.ExpectQuery("FROM withdrawal_tokens w JOIN transactions t ON t.id = w.transaction_id WHERE w.code_hash = \\?").
+				WithArgs(s.hashWithdrawalCode("254700000003", "12345678")).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "user_id", "msisdn", "amount", "channel", "status", "expires_at", "redeemed_at", "redeemed_by", "created_at", "reference_id"}).
+					AddRow(11, 7, 3, "254700000003", 100.0, models.WithdrawalChannelATM, tt.status, tt.expiresAt, nil, nil, time.Now(), "WD1"))
+			status := models.TransactionStatusCompleted
+			switch tt.wantErr {
+			case nil:
+				mock.ExpectExec("UPDATE users SET held_balance = held_balance - \\?").WithArgs(100.0, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE withdrawal_tokens SET status = \\?, redeemed_at = \\?").
+					WithArgs(models.WithdrawalTokenRedeemed, sqlmock.AnyArg(), "ATM-1", int64(11)).WillReturnResult(sqlmock.NewResult(0, 1))
+			case ErrCodeExpired:
+				status = models.TransactionStatusFailed
+				mock.ExpectExec("UPDATE users SET balance = balance \\+ \\?, held_balance = held_balance - \\?").
+					WithArgs(100.0, 100.0, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE withdrawal_tokens SET status = \\?").WithArgs(models.WithdrawalTokenExpired, int64(11)).WillReturnResult(sqlmock.NewResult(0, 1))
+			default:
+				mock.ExpectRollback()
+			}
+			if tt.wantErr == nil || tt.wantErr == ErrCodeExpired {
+				mock.ExpectQuery("SELECT metadata FROM transactions WHERE id = \\? FOR UPDATE").
+					WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{"hold":true}`)))
+				mock.ExpectExec("UPDATE transactions SET status = \\?").WithArgs(status, sqlmock.AnyArg(), int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			_, err := s.RedeemWithdrawalCode(context.Background(), RedeemRequest{
+				MSISDN: "254700000003", Code: "12345678", Amount: 100, Channel: tt.channel, TerminalID: "ATM-1",
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RedeemWithdrawalCode = %v, want %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHashWithdrawalCode(t *testing.T) {
+	s := &Service{config: Config{WithdrawalCodeSecret: testCodeSecret}}
+	other := &Service{config: Config{WithdrawalCodeSecret: testCodeSecret + "x"}}
+
+	hash := s.hashWithdrawalCode("254700000003", "12345678")
+	if hash == s.hashWithdrawalCode("254700000004", "12345678") {
+		t.Error("hash does not depend on the msisdn")
+	}
+	if hash == other.hashWithdrawalCode("254700000003", "12345678") {
+		t.Error("hash does not depend on the secret")
+	}
+}